@@ -1,33 +1,38 @@
 package agtapi
 
 import (
-	"context"
-	"net"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 
 	"github.com/vela-ssoc/ssoc-broker/app/route"
+	"github.com/vela-ssoc/ssoc-broker/bridge/telecom"
 	"github.com/xgfone/ship/v5"
 )
 
-func Proxy(dc func(context.Context, string, string) (net.Conn, error)) route.Router {
+// siemBodySize 转发到 SIEM 的单个请求体上限，转发的请求需要完整读入内存以便隧道断开时落盘。
+const siemBodySize = 16 * 1024 * 1024
+
+func Proxy(link telecom.Linker) route.Router {
 	rawURL, _ := url.Parse("http://vtun/proxy/siem")
 	siem := &httputil.ReverseProxy{
 		Rewrite: func(pr *httputil.ProxyRequest) {
 			pr.SetURL(rawURL)
 		},
 		Transport: &http.Transport{
-			DialContext: dc,
+			DialContext: link.DialContext,
 		},
 	}
 
 	return &proxyAPI{
+		link: link,
 		siem: siem,
 	}
 }
 
 type proxyAPI struct {
+	link telecom.Linker
 	siem *httputil.ReverseProxy
 }
 
@@ -37,11 +42,56 @@ func (api *proxyAPI) Route(r *ship.RouteGroupBuilder) {
 }
 
 // siem 通过 tunnel 方式代理向 SIEM 平台发起请求。
+//
+// 节点推送数据（POST PUT）通过 Deliver 投递：送达时原样返回 SIEM 的响应，隧道往返失败时写入离线队列，
+// 恢复后按顺序重放，此时返回 202；查询等其它请求不落盘，仍然以反向代理的方式转发。
 func (api *proxyAPI) siemFunc(c *ship.Context) error {
 	path := "/" + c.Param("path")
 	r, w := c.Request(), c.Response()
-	r.URL.Path, r.RequestURI = path, path
-	api.siem.ServeHTTP(w, r)
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		r.URL.Path, r.RequestURI = path, path
+		api.siem.ServeHTTP(w, r)
+		return nil
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, siemBodySize))
+	if err != nil {
+		return ship.ErrStatusRequestEntityTooLarge.New(err)
+	}
+	header := make(http.Header, 2)
+	for _, key := range []string{ship.HeaderContentType, ship.HeaderContentEncoding} {
+		if val := r.Header.Get(key); val != "" {
+			header.Set(key, val)
+		}
+	}
+	dest := &url.URL{Path: "/proxy/siem" + path, RawQuery: r.URL.RawQuery}
+	res, err := api.link.Deliver(r.Context(), r.Method, dest.RequestURI(), body, header)
+	if err != nil {
+		return err
+	}
+	if res == nil { // 已写入离线队列
+		return c.NoContent(http.StatusAccepted)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer res.Body.Close()
+
+	for k, vs := range res.Header {
+		if _, hop := hopHeaders[k]; !hop {
+			w.Header()[k] = vs
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	_, err = io.Copy(w, res.Body)
+
+	return err
+}
 
-	return nil
+// hopHeaders 逐跳响应头，不应该转发给节点。
+var hopHeaders = map[string]struct{}{
+	"Connection":        {},
+	"Keep-Alive":        {},
+	"Proxy-Connection":  {},
+	"Trailer":           {},
+	"Transfer-Encoding": {},
+	"Upgrade":           {},
 }
@@ -2,6 +2,7 @@ package agtapi
 
 import (
 	"net/http"
+	"net/netip"
 	"time"
 
 	"github.com/vela-ssoc/ssoc-broker/app/internal/param"
@@ -43,14 +44,15 @@ func (rest *securityREST) RiskIP(c *ship.Context) error {
 
 	now := time.Now()
 	ctx := c.Request().Context()
+	ips, origin := canonicalIPs(body.Data)
 	tbl := rest.qry.RiskIP
 	dao := tbl.WithContext(ctx).
-		Where(tbl.IP.In(body.Data...), tbl.BeforeAt.Gte(now))
+		Where(tbl.IP.In(ips...), tbl.BeforeAt.Gte(now))
 	if len(qry.Kind) != 0 {
 		dao.Where(tbl.Kind.In(qry.Kind...))
 	}
 	dats, _ := dao.Limit(rest.limit).Find()
-	kinds := model.RiskIPs(dats).IPKinds()
+	kinds := restoreIPs(model.RiskIPs(dats).IPKinds(), origin)
 	res := &param.SecurityResult{
 		Count: len(kinds),
 		Data:  kinds,
@@ -71,14 +73,15 @@ func (rest *securityREST) PassIP(c *ship.Context) error {
 
 	now := time.Now()
 	ctx := c.Request().Context()
+	ips, origin := canonicalIPs(body.Data)
 	tbl := rest.qry.PassIP
 	dao := tbl.WithContext(ctx).
-		Where(tbl.IP.In(body.Data...), tbl.BeforeAt.Gte(now))
+		Where(tbl.IP.In(ips...), tbl.BeforeAt.Gte(now))
 	if len(qry.Kind) != 0 {
 		dao.Where(tbl.Kind.In(qry.Kind...))
 	}
 	dats, _ := dao.Limit(rest.limit).Find()
-	kinds := model.PassIPs(dats).IPKinds()
+	kinds := restoreIPs(model.PassIPs(dats).IPKinds(), origin)
 	res := &param.SecurityResult{
 		Count: len(kinds),
 		Data:  kinds,
@@ -171,3 +174,39 @@ func (rest *securityREST) RiskFile(c *ship.Context) error {
 
 	return c.JSON(http.StatusOK, res)
 }
+
+// canonicalIPs 将 IP 转为规范格式后再查询，IPv6 同一个地址可以有多种写法
+// （如：大小写、省略零、IPv4 映射地址），库中统一按照规范格式存储。
+// origin 记录了规范格式与请求中原始写法的对应关系。
+func canonicalIPs(ips []string) ([]string, map[string][]string) {
+	ret := make([]string, 0, len(ips))
+	origin := make(map[string][]string, len(ips))
+	for _, ip := range ips {
+		key := ip
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			key = addr.Unmap().WithZone("").String()
+		}
+		if _, exists := origin[key]; !exists {
+			ret = append(ret, key)
+		}
+		origin[key] = append(origin[key], ip)
+	}
+
+	return ret, origin
+}
+
+// restoreIPs 将查询结果的 key 还原为请求中的原始写法，方便调用方直接匹配。
+func restoreIPs(kinds map[string][]string, origin map[string][]string) map[string][]string {
+	ret := make(map[string][]string, len(kinds))
+	for key, kind := range kinds {
+		if raws, ok := origin[key]; ok {
+			for _, raw := range raws {
+				ret[raw] = kind
+			}
+		} else {
+			ret[key] = kind
+		}
+	}
+
+	return ret
+}
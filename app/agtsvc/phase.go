@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/vela-ssoc/ssoc-broker/app/mgtsvc"
-	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
 	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
 	"github.com/vela-ssoc/ssoc-common-mb/dal/model"
 	"github.com/vela-ssoc/ssoc-common-mb/gopool"
@@ -15,8 +14,14 @@ import (
 	"github.com/vela-ssoc/ssoc-common-mb/integration/cmdb"
 )
 
+// PhaseService 节点生命周期事件总线的内置订阅者：事件入库与告警。
 type PhaseService interface {
-	mlink.NodePhaser
+	mlink.PhaseSubscriber
+
+	// Push 节点上线时下发 startup 与配置，新节点注册时拉取 cmdb 信息。
+	// 作为事件总线的钩子同步调用，不会因为队列已满被丢弃，实际的下发在协程池中执行。
+	Push(evt *mlink.PhaseEvent)
+
 	SetService(svc mgtsvc.AgentService)
 }
 
@@ -41,51 +46,80 @@ func (biz *nodeEventService) SetService(svc mgtsvc.AgentService) {
 	biz.svc = svc
 }
 
-func (biz *nodeEventService) Repeated(id int64, ident gateway.Ident, at time.Time) {
+func (biz *nodeEventService) Name() string { return "event" }
+
+func (biz *nodeEventService) Push(evt *mlink.PhaseEvent) {
+	switch evt.Type {
+	case mlink.PhaseCreated:
+		biz.created(evt.MinionID, evt.Inet)
+	case mlink.PhaseConnected:
+		// 推送 startup 与配置脚本
+		ctx := context.Background()
+		_ = biz.svc.ReloadStartup(ctx, evt.MinionID)
+		_ = biz.svc.RsyncTask(ctx, []int64{evt.MinionID})
+	}
 }
 
-func (biz *nodeEventService) Connected(lnk mlink.Linker, ident gateway.Ident, issue gateway.Issue, at time.Time) {
-	mid, inet := issue.ID, ident.Inet.String()
-	biz.log.Info("Agent 上线", slog.Int64("minion_id", mid), slog.String("inet", inet))
-
-	// 推送 startup 与配置脚本
-	ctx := context.Background()
-	_ = biz.svc.ReloadStartup(ctx, mid)
-	_ = biz.svc.RsyncTask(ctx, []int64{mid})
-
-	msg := fmt.Sprintf("当前 agent 版本：%s", ident.Semver)
-	now := time.Now()
-	evt := &model.Event{
-		MinionID:  mid,
-		Inet:      inet,
-		Subject:   "节点上线",
-		FromCode:  "minion.online",
-		Msg:       msg,
-		Level:     model.ELvlNote,
-		SendAlert: true,
-		OccurAt:   now,
-		CreatedAt: now,
+func (biz *nodeEventService) Handle(ctx context.Context, evt *mlink.PhaseEvent) error {
+	dat := biz.event(evt)
+	if dat == nil {
+		return nil
+	}
+
+	attrs := []any{slog.Int64("minion_id", evt.MinionID), slog.String("inet", evt.Inet), slog.String("msg", dat.Msg)}
+	if dat.Level == model.ELvlNote {
+		biz.log.Info(dat.Subject, attrs...)
+	} else {
+		biz.log.Warn(dat.Subject, attrs...)
 	}
-	_ = biz.alert.EventSaveAndAlert(ctx, evt)
+
+	return biz.alert.EventSaveAndAlert(ctx, dat)
 }
 
-func (biz *nodeEventService) Disconnected(lnk mlink.Linker, ident gateway.Ident, issue gateway.Issue, at time.Time, du time.Duration) {
-	mid, inet := issue.ID, ident.Inet.String()
-	biz.log.Warn("Agent 下线", slog.Int64("minion_id", mid), slog.String("inet", inet))
-
-	msg := fmt.Sprintf("当前 agent 版本：%s", ident.Semver)
-	now := time.Now()
-	evt := &model.Event{
-		MinionID:  mid,
-		Inet:      inet,
-		Subject:   "节点下线",
-		FromCode:  "minion.offline",
-		Msg:       msg,
-		Level:     model.ELvlMajor,
-		SendAlert: true,
-		OccurAt:   now,
-		CreatedAt: now,
+// event 将生命周期事件转换为需要入库的事件，不需要入库的事件类型返回 nil。
+func (biz *nodeEventService) event(evt *mlink.PhaseEvent) *model.Event {
+	var hostname, semver string
+	if ident := evt.Ident; ident != nil {
+		hostname, semver = ident.Hostname, ident.Semver
+	}
+
+	dat := &model.Event{
+		MinionID:  evt.MinionID,
+		Inet:      evt.Inet,
+		Level:     model.ELvlNote,
+		OccurAt:   evt.At,
+		CreatedAt: time.Now(),
+	}
+	switch evt.Type {
+	case mlink.PhaseConflicted:
+		dat.Subject, dat.FromCode = "节点身份冲突", "minion.conflict"
+		dat.Msg = fmt.Sprintf("%s，处理策略：%s", evt.Reason, evt.Policy)
+		dat.Level, dat.SendAlert = model.ELvlMajor, true
+	case mlink.PhaseRefused:
+		dat.Subject, dat.FromCode = "节点准入被拒绝", "minion.refused"
+		dat.Msg = fmt.Sprintf("来源地址：%s，主机名：%s，拒绝原因：%s", evt.Peer, hostname, evt.Reason)
+		dat.Level, dat.SendAlert = model.ELvlMajor, true
+	case mlink.PhaseMismatched:
+		dat.Subject, dat.FromCode = "节点地址不一致", "minion.mismatch"
+		dat.Msg = fmt.Sprintf("节点声明的地址为 %s，实际来源地址为 %s，主机名：%s", evt.Inet, evt.Peer, hostname)
+	case mlink.PhaseReconciled:
+		dat.Subject, dat.FromCode = "节点状态校对", "minion.reconcile"
+		dat.Msg = evt.Reason
+	case mlink.PhaseFlapping:
+		dat.Subject, dat.FromCode = "节点频繁上下线", "minion.flapping"
+		dat.Msg = fmt.Sprintf("节点在 %s 内断开重连了 %d 次，请检查网络是否稳定，当前 agent 版本：%s", evt.Duration, evt.Count, semver)
+		dat.Level, dat.SendAlert = model.ELvlMajor, true
+	case mlink.PhaseConnected:
+		dat.Subject, dat.FromCode = "节点上线", "minion.online"
+		dat.Msg = fmt.Sprintf("当前 agent 版本：%s", semver)
+		dat.SendAlert = true
+	case mlink.PhaseDisconnected:
+		dat.Subject, dat.FromCode = "节点下线", "minion.offline"
+		dat.Msg = fmt.Sprintf("当前 agent 版本：%s", semver)
+		dat.Level, dat.SendAlert = model.ELvlMajor, true
+	default:
+		return nil
 	}
 
-	_ = biz.alert.EventSaveAndAlert(context.Background(), evt)
+	return dat
 }
@@ -8,8 +8,8 @@ import (
 	"github.com/vela-ssoc/ssoc-common-mb/integration/cmdb"
 )
 
-func (biz *nodeEventService) Created(id int64, inet string, at time.Time) {
-	// 查询状态
+// created 新节点注册后拉取 cmdb 信息。
+func (biz *nodeEventService) created(id int64, inet string) {
 	ct := &cmdbTask{
 		cli:  biz.cmdbc,
 		id:   id,
@@ -1,9 +1,18 @@
 package param
 
-import "github.com/vela-ssoc/ssoc-common-mb/param/negotiate"
+import (
+	"github.com/vela-ssoc/ssoc-broker/bridge/telecom"
+	"github.com/vela-ssoc/ssoc-common-mb/param/negotiate"
+)
 
 type PprofConfig struct {
-	Hide  negotiate.Hide  `json:"hide"`
+	Hide  telecom.Hide    `json:"hide"`
 	Ident negotiate.Ident `json:"ident"`
 	Issue negotiate.Issue `json:"issue"`
+
+	// Addresses 中心端各个地址的健康状态与评分。
+	Addresses []telecom.AddressStat `json:"addresses"`
+
+	// Spool 离线消息队列的积压与丢弃统计。
+	Spool telecom.SpoolStat `json:"spool"`
 }
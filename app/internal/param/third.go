@@ -5,7 +5,11 @@ type ThirdDownload struct {
 	Hash string `json:"hash" query:"hash"`
 }
 
+// ThirdDiff 三方文件变更通知，Goos、Arch、Tags 用于缩小通知范围，都为空时通知所有在线节点。
 type ThirdDiff struct {
-	Name  string `json:"name"`
-	Event string `json:"event"`
+	Name  string   `json:"name"`
+	Event string   `json:"event"`
+	Goos  []string `json:"goos"  validate:"lte=10,dive,required"`
+	Arch  []string `json:"arch"  validate:"lte=10,dive,required"`
+	Tags  []string `json:"tags"  validate:"lte=100,dive,required"`
 }
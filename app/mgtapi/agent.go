@@ -1,6 +1,9 @@
 package mgtapi
 
 import (
+	"net/http"
+
+	"github.com/vela-ssoc/ssoc-broker/app/internal/param"
 	"github.com/vela-ssoc/ssoc-broker/app/mgtsvc"
 	"github.com/vela-ssoc/ssoc-broker/app/route"
 	"github.com/vela-ssoc/ssoc-common-mb/accord"
@@ -25,6 +28,7 @@ func (rest *agentREST) Route(r *ship.RouteGroupBuilder) {
 	r.Route(accord.PathTaskLoad).Data(route.Named("通知节点重启配置")).POST(rest.ReloadTask)
 	r.Route(accord.PathTaskTable).Data(route.Named("通知扫表任务")).POST(rest.TableTask)
 	r.Route(accord.PathThirdDiff).Data(route.Named("通知三方文件更新")).POST(rest.ThirdDiff)
+	r.Route("/brr/multicast/job").Data(route.Named("查询节点命令下发结果")).GET(rest.MulticastJob)
 }
 
 func (rest *agentREST) Upgrade(c *ship.Context) error {
@@ -34,8 +38,12 @@ func (rest *agentREST) Upgrade(c *ship.Context) error {
 	}
 
 	ctx := c.Request().Context()
+	ret, err := rest.svc.Upgrade(ctx, &req)
+	if err != nil {
+		return err
+	}
 
-	return rest.svc.Upgrade(ctx, &req)
+	return c.JSON(http.StatusOK, ret)
 }
 
 func (rest *agentREST) Startup(c *ship.Context) error {
@@ -57,14 +65,21 @@ func (rest *agentREST) Command(c *ship.Context) error {
 	}
 	ctx := c.Request().Context()
 
+	var ret *mgtsvc.MulticastJob
+	var err error
 	switch req.Cmd {
 	case "resync":
 		return rest.svc.RsyncTask(ctx, req.ID)
 	case "upgrade":
-		return rest.svc.Upgrade(ctx, &accord.Upgrade{ID: req.ID})
+		ret, err = rest.svc.Upgrade(ctx, &accord.Upgrade{ID: req.ID})
+	default:
+		ret, err = rest.svc.Command(ctx, req.ID, req.Cmd)
+	}
+	if err != nil {
+		return err
 	}
 
-	return rest.svc.Command(ctx, req.ID, req.Cmd)
+	return c.JSON(http.StatusOK, ret)
 }
 
 func (rest *agentREST) Offline(c *ship.Context) error {
@@ -73,8 +88,12 @@ func (rest *agentREST) Offline(c *ship.Context) error {
 		return err
 	}
 	ctx := c.Request().Context()
+	ret, err := rest.svc.Command(ctx, req.ID, "offline")
+	if err != nil {
+		return err
+	}
 
-	return rest.svc.Command(ctx, req.ID, "offline")
+	return c.JSON(http.StatusOK, ret)
 }
 
 func (rest *agentREST) RsyncTask(c *ship.Context) error {
@@ -108,11 +127,25 @@ func (rest *agentREST) ReloadTask(c *ship.Context) error {
 }
 
 func (rest *agentREST) ThirdDiff(c *ship.Context) error {
-	var req accord.ThirdDiff
+	var req param.ThirdDiff
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
 	ctx := c.Request().Context()
 
-	return rest.svc.ThirdDiff(ctx, req.Name, req.Event)
+	return rest.svc.ThirdDiff(ctx, &req)
+}
+
+func (rest *agentREST) MulticastJob(c *ship.Context) error {
+	id := c.Query("id")
+	if id == "" {
+		return ship.ErrBadRequest.Newf("任务 ID 不能为空")
+	}
+	ctx := c.Request().Context()
+	ret, err := rest.svc.MulticastJob(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, ret)
 }
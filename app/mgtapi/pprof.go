@@ -31,14 +31,16 @@ func (rest *pprofREST) Route(r *ship.RouteGroupBuilder) {
 }
 
 func (rest *pprofREST) Config(c *ship.Context) error {
-	hide := rest.lnk.Hide()
+	hide := rest.lnk.Hide().Redacted() // 客户端证书私钥、代理密码不对外展示
 	ident := rest.lnk.Ident()
 	issue := rest.lnk.Issue()
 
 	res := &param.PprofConfig{
-		Hide:  hide,
-		Ident: ident,
-		Issue: issue,
+		Hide:      hide,
+		Ident:     ident,
+		Issue:     issue,
+		Addresses: rest.lnk.Addresses(),
+		Spool:     rest.lnk.Spool(),
 	}
 
 	return c.JSON(http.StatusOK, res)
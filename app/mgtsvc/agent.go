@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 
+	"github.com/vela-ssoc/ssoc-broker/app/internal/param"
 	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
 	"github.com/vela-ssoc/ssoc-common-mb/accord"
 	"github.com/vela-ssoc/ssoc-common-mb/dal/query"
@@ -24,14 +25,17 @@ type AgentService interface {
 	// ReloadStartup 重新加载指定节点的 startup 配置。
 	ReloadStartup(ctx context.Context, mid int64) error
 
-	// ThirdDiff 通知 agent 节点三方文件发生了变更。
-	ThirdDiff(ctx context.Context, name, event string) error
+	// ThirdDiff 通知 agent 节点三方文件发生了变更，只通知符合筛选条件的在线节点。
+	ThirdDiff(ctx context.Context, req *param.ThirdDiff) error
 
-	// Command 向节点发送命令
-	Command(ctx context.Context, mids []int64, cmd string) error
+	// Command 向节点发送命令，返回异步任务，通过 MulticastJob 查询每个节点的执行结果。
+	Command(ctx context.Context, mids []int64, cmd string) (*MulticastJob, error)
 
-	// Upgrade 向节点发送升级命令
-	Upgrade(ctx context.Context, req *accord.Upgrade) error
+	// Upgrade 向节点发送升级命令，返回异步任务，通过 MulticastJob 查询每个节点的执行结果。
+	Upgrade(ctx context.Context, req *accord.Upgrade) (*MulticastJob, error)
+
+	// MulticastJob 查询多播任务的进度和各个节点的执行结果。
+	MulticastJob(ctx context.Context, id string) (*MulticastJob, error)
 }
 
 func Agent(qry *query.Query, lnk mlink.Linker, mon MinionService, store storage.Storer, log *slog.Logger) AgentService {
@@ -43,6 +47,7 @@ func Agent(qry *query.Query, lnk mlink.Linker, mon MinionService, store storage.
 		log:   log,
 		pool:  gopool.NewV2(512),
 		cycle: 5,
+		jobs:  multicastJobs{jobs: make(map[string]*MulticastJob, 16)},
 	}
 }
 
@@ -54,4 +59,5 @@ type agentService struct {
 	log   *slog.Logger
 	pool  gopool.Pool
 	cycle int
+	jobs  multicastJobs
 }
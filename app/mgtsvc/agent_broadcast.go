@@ -2,31 +2,35 @@ package mgtsvc
 
 import (
 	"context"
+	"log/slog"
 	"time"
 )
 
-func (biz *agentService) broadcast(path string, data any) {
-	ids := biz.lnk.ConnectIDs()
-	for _, mid := range ids {
-		task := &messageTask{
-			biz:  biz,
-			mid:  mid,
-			path: path,
-			data: data,
-		}
-		biz.pool.Go(task.Run)
-	}
+// broadcast 异步向指定节点广播消息，并发数由 mlink 多播限制，不会阻塞调用方。
+func (biz *agentService) broadcast(ids []int64, path string, data any) {
+	task := &broadcastTask{biz: biz, ids: ids, path: path, data: data}
+	biz.pool.Go(task.Run)
 }
 
-type messageTask struct {
+type broadcastTask struct {
 	biz  *agentService
-	mid  int64
+	ids  []int64
 	path string
 	data any
 }
 
-func (mt *messageTask) Run() {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (bt *broadcastTask) Run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
-	_ = mt.biz.lnk.Oneway(ctx, mt.mid, mt.path, mt.data)
+
+	var failed int
+	for res := range bt.biz.lnk.Multicast(ctx, bt.ids, bt.path, bt.data) {
+		if !res.Succeed() {
+			failed++
+		}
+	}
+	if failed != 0 {
+		bt.biz.log.Warn("广播消息部分节点失败", slog.String("path", bt.path),
+			slog.Int("total", len(bt.ids)), slog.Int("failed", failed))
+	}
 }
@@ -2,35 +2,21 @@ package mgtsvc
 
 import (
 	"context"
-	"time"
 
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
 	"github.com/vela-ssoc/ssoc-common-mb/accord"
 )
 
-func (biz *agentService) Command(_ context.Context, mids []int64, cmd string) error {
-	for _, mid := range mids {
-		task := &commandTask{biz: biz, mid: mid, cmd: cmd}
-		biz.pool.Go(task.Run)
-	}
-	return nil
-}
-
-type commandTask struct {
-	biz *agentService
-	mid int64
-	cmd string
-}
-
-func (ct *commandTask) Run() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	defer cancel()
-
-	dat := &accord.Command{Cmd: ct.cmd}
+func (biz *agentService) Command(ctx context.Context, mids []int64, cmd string) (*MulticastJob, error) {
+	dat := &accord.Command{Cmd: cmd}
 	path := "/api/v1/agent/notice/command"
 
-	lnk := ct.biz.lnk
-	_ = lnk.Oneway(ctx, ct.mid, path, dat)
-	if ct.cmd == "offline" || ct.cmd == "restart" {
-		lnk.Knockout(ct.mid)
+	var each func(*mlink.MulticastResult)
+	if cmd == "offline" || cmd == "restart" {
+		each = func(res *mlink.MulticastResult) {
+			_ = biz.lnk.Knockout(context.Background(), res.ID, "中心端下发了 "+cmd+" 命令", 0)
+		}
 	}
+
+	return biz.jobs.start(ctx, biz.lnk, mids, path, dat, each), nil
 }
@@ -0,0 +1,126 @@
+package mgtsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
+)
+
+const (
+	multicastJobTTL   = 30 * time.Minute // 已完成的任务保留时长
+	multicastJobLimit = 256              // 最多保留的任务数
+)
+
+var errJobNotFound = errors.New("任务不存在或已过期")
+
+// MulticastJob 向多个节点下发消息的异步任务：下发节点数不受单次请求时长的限制，
+// 中心端拿到任务 ID 后轮询各个节点的执行结果。
+type MulticastJob struct {
+	ID         string                   `json:"id"`
+	Path       string                   `json:"path"`
+	Total      int                      `json:"total"`    // 节点总数
+	Finished   int                      `json:"finished"` // 已完成的节点数
+	Failed     int                      `json:"failed"`   // 失败的节点数
+	CreatedAt  time.Time                `json:"created_at"`
+	FinishedAt *time.Time               `json:"finished_at,omitempty"` // 全部节点完成的时间
+	Results    []*mlink.MulticastResult `json:"results,omitempty"`     // 已完成节点的执行结果
+}
+
+// multicastJobs 进行中和最近完成的多播任务，只保存在内存中。
+type multicastJobs struct {
+	mutex sync.Mutex
+	jobs  map[string]*MulticastJob
+}
+
+// start 开启多播任务并立即返回，each 在每个节点完成后调用。
+func (mj *multicastJobs) start(ctx context.Context, lnk mlink.Linker, ids []int64, path string, body any, each func(*mlink.MulticastResult)) *MulticastJob {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	now := time.Now()
+	job := &MulticastJob{
+		ID:        hex.EncodeToString(buf),
+		Path:      path,
+		Total:     len(ids),
+		CreatedAt: now,
+		Results:   make([]*mlink.MulticastResult, 0, len(ids)),
+	}
+
+	mj.mutex.Lock()
+	mj.prune(now)
+	mj.jobs[job.ID] = job
+	ret := job.clone()
+	mj.mutex.Unlock()
+
+	// 中心端不再等待结果时也要继续下发完。
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for res := range lnk.Multicast(ctx, ids, path, body) {
+			if each != nil {
+				each(res)
+			}
+			mj.mutex.Lock()
+			job.Finished++
+			if !res.Succeed() {
+				job.Failed++
+			}
+			job.Results = append(job.Results, res)
+			mj.mutex.Unlock()
+		}
+
+		finishedAt := time.Now()
+		mj.mutex.Lock()
+		job.FinishedAt = &finishedAt
+		mj.mutex.Unlock()
+	}()
+
+	return ret
+}
+
+func (mj *multicastJobs) get(id string) (*MulticastJob, error) {
+	mj.mutex.Lock()
+	defer mj.mutex.Unlock()
+
+	job := mj.jobs[id]
+	if job == nil {
+		return nil, errJobNotFound
+	}
+
+	return job.clone(), nil
+}
+
+// prune 清理过期的任务，任务数超过上限时优先清理最早完成的任务，进行中的任务不会被清理。
+func (mj *multicastJobs) prune(now time.Time) {
+	finished := make([]*MulticastJob, 0, len(mj.jobs))
+	for id, job := range mj.jobs {
+		if job.FinishedAt == nil {
+			continue
+		}
+		if now.Sub(*job.FinishedAt) > multicastJobTTL {
+			delete(mj.jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+	if over := len(mj.jobs) - multicastJobLimit + 1; over > 0 {
+		slices.SortFunc(finished, func(a, b *MulticastJob) int { return a.FinishedAt.Compare(*b.FinishedAt) })
+		for _, job := range finished[:min(over, len(finished))] {
+			delete(mj.jobs, job.ID)
+		}
+	}
+}
+
+func (job *MulticastJob) clone() *MulticastJob {
+	ret := *job
+	ret.Results = slices.Clone(job.Results)
+	return &ret
+}
+
+func (biz *agentService) MulticastJob(_ context.Context, id string) (*MulticastJob, error) {
+	return biz.jobs.get(id)
+}
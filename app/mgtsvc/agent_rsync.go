@@ -22,6 +22,9 @@ func (biz *agentService) rsyncTask(ctx context.Context, mid int64) error {
 	if err != nil {
 		return err
 	}
+	// 节点标签变更后都会同步配置，顺便刷新注册表中缓存的标签。
+	_ = biz.lnk.Retag(ctx, mid)
+
 	// 2. 同步配置
 	return biz.rsync(ctx, light)
 }
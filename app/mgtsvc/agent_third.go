@@ -3,11 +3,19 @@ package mgtsvc
 import (
 	"context"
 
+	"github.com/vela-ssoc/ssoc-broker/app/internal/param"
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
 	"github.com/vela-ssoc/ssoc-common-mb/accord"
 )
 
-func (biz *agentService) ThirdDiff(_ context.Context, name, event string) error {
-	req := &accord.ThirdDiff{Name: name, Event: event}
-	go biz.broadcast("/api/v1/agent/third/diff", req)
+func (biz *agentService) ThirdDiff(_ context.Context, req *param.ThirdDiff) error {
+	ids := biz.lnk.Select(mlink.Filter{Goos: req.Goos, Arch: req.Arch, Tags: req.Tags})
+	if len(ids) == 0 {
+		return nil
+	}
+
+	data := &accord.ThirdDiff{Name: req.Name, Event: req.Event}
+	biz.broadcast(ids, "/api/v1/agent/third/diff", data)
+
 	return nil
 }
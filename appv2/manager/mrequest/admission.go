@@ -0,0 +1,6 @@
+package mrequest
+
+type AdmissionReload struct {
+	// Reload 是否立即从数据库重新加载准入策略。
+	Reload bool `json:"reload" query:"reload"`
+}
@@ -0,0 +1,20 @@
+package mrequest
+
+type MinionBan struct {
+	// MinionID 按照节点 ID 封禁，与 Inet 二选一。
+	MinionID int64 `json:"minion_id" validate:"required_without=Inet"`
+
+	// Inet 按照节点 IP 封禁，与 MinionID 二选一。
+	Inet string `json:"inet" validate:"omitempty,ip"`
+
+	// Reason 封禁原因，节点接入时会收到该原因。
+	Reason string `json:"reason" validate:"lte=255"`
+
+	// Duration 封禁时长（秒），为 0 代表永久封禁，最长 31536000 秒（一年）。
+	Duration int `json:"duration" validate:"gte=0,lte=31536000"`
+}
+
+type MinionUnban struct {
+	MinionID int64  `json:"minion_id" query:"minion_id" validate:"required_without=Inet"`
+	Inet     string `json:"inet"      query:"inet"      validate:"omitempty,ip"`
+}
@@ -0,0 +1,31 @@
+package mrequest
+
+type MinionCapture struct {
+	MinionID int64 `json:"minion_id" validate:"required"`
+
+	// Duration 抓包时长（秒），到期后自动停止，为 0 时默认 600 秒，最长 86400 秒。
+	Duration int `json:"duration" validate:"gte=0,lte=86400"`
+
+	// MaxBody 单个请求或响应 Body 最多记录的字节数，为 0 时默认 64KiB。
+	MaxBody int `json:"max_body" validate:"gte=0,lte=16777216"`
+
+	// MaxSize 单个抓包文件的大小上限，超过后滚动，为 0 时默认 32MiB。
+	MaxSize int64 `json:"max_size" validate:"gte=0"`
+
+	// MaxFiles 最多保留的抓包文件数，为 0 时默认 5 个。
+	MaxFiles int `json:"max_files" validate:"gte=0,lte=100"`
+
+	// Redact 除内置规则外，额外需要脱敏的 Header、Query 参数和 JSON 字段名。
+	Redact []string `json:"redact" validate:"lte=100,dive,required"`
+}
+
+type MinionCaptureID struct {
+	MinionID int64 `json:"minion_id" query:"minion_id" validate:"required"`
+}
+
+type MinionCaptureFile struct {
+	MinionID int64 `json:"minion_id" query:"minion_id" validate:"required"`
+
+	// Index 抓包文件序号，0 为当前文件，滚动后的旧文件依次为 1 2 ...
+	Index int `json:"index" query:"index" validate:"gte=0,lte=100"`
+}
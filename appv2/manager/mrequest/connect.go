@@ -0,0 +1,8 @@
+package mrequest
+
+type ConnectFilter struct {
+	ID     int64  `json:"id"     query:"id"`
+	Inet   string `json:"inet"   query:"inet"`
+	Goos   string `json:"goos"   query:"goos"`
+	Semver string `json:"semver" query:"version"`
+}
@@ -0,0 +1,31 @@
+package mrequest
+
+import "time"
+
+type SystemDrain struct {
+	// Broker 迁移目标 broker 的名字。
+	Broker string `json:"broker" validate:"required"`
+
+	// Addrs 迁移目标 broker 的地址，随重连命令下发给 agent，agent 改为连接这些地址。
+	Addrs []string `json:"addrs" validate:"gte=1,lte=16,dive,required"`
+
+	// Batch 每批通知的节点数，为 0 时默认 50。
+	Batch int `json:"batch" validate:"gte=0,lte=1000"`
+
+	// Interval 每批通知之间的间隔（秒），为 0 时默认 5 秒，最长 600 秒。
+	Interval int `json:"interval" validate:"gte=0,lte=600"`
+
+	// Deadline 排空的最长等待时间（秒），超时后无论是否还有节点在线都会退出，为 0 时默认 600 秒，最长 86400 秒。
+	Deadline int `json:"deadline" validate:"gte=0,lte=86400"`
+}
+
+type DrainStatus struct {
+	Draining  bool      `json:"draining"` // 是否处于排空状态
+	Broker    string    `json:"broker"`   // 迁移目标 broker
+	Total     int       `json:"total"`    // 开始排空时在线的节点数
+	Notified  int       `json:"notified"` // 已通知的节点数
+	Failed    int       `json:"failed"`   // 通知失败的节点数
+	Remain    int       `json:"remain"`   // 仍然在线的节点数
+	StartedAt time.Time `json:"started_at"`
+	Deadline  time.Time `json:"deadline"`
+}
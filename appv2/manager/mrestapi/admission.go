@@ -0,0 +1,33 @@
+package mrestapi
+
+import (
+	"net/http"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mservice"
+	"github.com/xgfone/ship/v5"
+)
+
+func NewAdmission(svc *mservice.Admission) *Admission {
+	return &Admission{svc: svc}
+}
+
+type Admission struct {
+	svc *mservice.Admission
+}
+
+func (adm *Admission) BindRoute(r *ship.RouteGroupBuilder) error {
+	r.Route("/minion/admission").GET(adm.policy)
+	return nil
+}
+
+func (adm *Admission) policy(c *ship.Context) error {
+	req := new(mrequest.AdmissionReload)
+	if err := c.BindQuery(req); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	ret := adm.svc.Policy(ctx, req)
+
+	return c.JSON(http.StatusOK, ret)
+}
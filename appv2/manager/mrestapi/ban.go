@@ -0,0 +1,55 @@
+package mrestapi
+
+import (
+	"net/http"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mservice"
+	"github.com/xgfone/ship/v5"
+)
+
+func NewBan(svc *mservice.Ban) *Ban {
+	return &Ban{svc: svc}
+}
+
+type Ban struct {
+	svc *mservice.Ban
+}
+
+func (bn *Ban) BindRoute(r *ship.RouteGroupBuilder) error {
+	r.Route("/minion/bans").GET(bn.list)
+	r.Route("/minion/ban").
+		POST(bn.create).
+		DELETE(bn.lift)
+	return nil
+}
+
+func (bn *Ban) list(c *ship.Context) error {
+	ctx := c.Request().Context()
+	ret, err := bn.svc.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, ret)
+}
+
+func (bn *Ban) create(c *ship.Context) error {
+	req := new(mrequest.MinionBan)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	return bn.svc.Create(ctx, req)
+}
+
+func (bn *Ban) lift(c *ship.Context) error {
+	req := new(mrequest.MinionUnban)
+	if err := c.BindQuery(req); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	return bn.svc.Lift(ctx, req)
+}
@@ -0,0 +1,86 @@
+package mrestapi
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mservice"
+	"github.com/xgfone/ship/v5"
+)
+
+func NewCapture(svc *mservice.Capture) *Capture {
+	return &Capture{svc: svc}
+}
+
+type Capture struct {
+	svc *mservice.Capture
+}
+
+func (cpt *Capture) BindRoute(r *ship.RouteGroupBuilder) error {
+	r.Route("/minion/captures").GET(cpt.list)
+	r.Route("/minion/capture").
+		POST(cpt.start).
+		DELETE(cpt.stop)
+	r.Route("/minion/capture/files").GET(cpt.files)
+	r.Route("/minion/capture/download").GET(cpt.download)
+	return nil
+}
+
+func (cpt *Capture) list(c *ship.Context) error {
+	ret := cpt.svc.List()
+	return c.JSON(http.StatusOK, ret)
+}
+
+func (cpt *Capture) start(c *ship.Context) error {
+	req := new(mrequest.MinionCapture)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	ret, err := cpt.svc.Start(req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, ret)
+}
+
+func (cpt *Capture) stop(c *ship.Context) error {
+	req := new(mrequest.MinionCaptureID)
+	if err := c.BindQuery(req); err != nil {
+		return err
+	}
+	ret, err := cpt.svc.Stop(req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, ret)
+}
+
+func (cpt *Capture) files(c *ship.Context) error {
+	req := new(mrequest.MinionCaptureID)
+	if err := c.BindQuery(req); err != nil {
+		return err
+	}
+	ret := cpt.svc.Files(req)
+
+	return c.JSON(http.StatusOK, ret)
+}
+
+func (cpt *Capture) download(c *ship.Context) error {
+	req := new(mrequest.MinionCaptureFile)
+	if err := c.BindQuery(req); err != nil {
+		return err
+	}
+	file, path, err := cpt.svc.Open(req)
+	if err != nil {
+		return err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer file.Close()
+
+	c.Header().Set(ship.HeaderContentDisposition, "attachment; filename="+filepath.Base(path))
+
+	return c.Stream(http.StatusOK, "application/x-ndjson", file)
+}
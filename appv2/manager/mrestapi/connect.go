@@ -0,0 +1,51 @@
+package mrestapi
+
+import (
+	"net/http"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mservice"
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
+	"github.com/xgfone/ship/v5"
+)
+
+func NewConnect(svc *mservice.Connect) *Connect {
+	return &Connect{svc: svc}
+}
+
+type Connect struct {
+	svc *mservice.Connect
+}
+
+func (cnt *Connect) BindRoute(r *ship.RouteGroupBuilder) error {
+	r.Route("/connect/stats").GET(cnt.stats)
+	r.Route("/connect/throttle").GET(cnt.throttle)
+	r.Route("/connect/select").POST(cnt.selects)
+	return nil
+}
+
+func (cnt *Connect) stats(c *ship.Context) error {
+	req := new(mrequest.ConnectFilter)
+	if err := c.BindQuery(req); err != nil {
+		return err
+	}
+
+	ret := cnt.svc.Stats(req)
+
+	return c.JSON(http.StatusOK, ret)
+}
+
+func (cnt *Connect) throttle(c *ship.Context) error {
+	ret := cnt.svc.Throttle()
+	return c.JSON(http.StatusOK, ret)
+}
+
+func (cnt *Connect) selects(c *ship.Context) error {
+	req := new(mlink.Filter)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	ret := cnt.svc.Select(req)
+
+	return c.JSON(http.StatusOK, ret)
+}
@@ -0,0 +1,37 @@
+package mrestapi
+
+import (
+	"net/http"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mservice"
+	"github.com/xgfone/ship/v5"
+)
+
+func NewDrain(svc *mservice.Drain) *Drain {
+	return &Drain{svc: svc}
+}
+
+type Drain struct {
+	svc *mservice.Drain
+}
+
+func (drn *Drain) BindRoute(r *ship.RouteGroupBuilder) error {
+	r.Route("/system/drain").POST(drn.start)
+	r.Route("/system/drain/status").GET(drn.status)
+	return nil
+}
+
+func (drn *Drain) start(c *ship.Context) error {
+	req := new(mrequest.SystemDrain)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	return drn.svc.Start(req)
+}
+
+func (drn *Drain) status(c *ship.Context) error {
+	ret := drn.svc.Status()
+	return c.JSON(http.StatusOK, ret)
+}
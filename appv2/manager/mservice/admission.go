@@ -0,0 +1,21 @@
+package mservice
+
+import (
+	"context"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
+)
+
+func NewAdmission(hub mlink.Linker) *Admission {
+	return &Admission{hub: hub}
+}
+
+// Admission 节点准入策略，策略保存在 broker 运行时配置中，修改后可以通过 reload 立即生效。
+type Admission struct {
+	hub mlink.Linker
+}
+
+func (adm *Admission) Policy(ctx context.Context, req *mrequest.AdmissionReload) *mlink.AdmissionPolicy {
+	return adm.hub.Admission(ctx, req.Reload)
+}
@@ -0,0 +1,48 @@
+package mservice
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
+)
+
+func NewBan(hub mlink.Linker, log *slog.Logger) *Ban {
+	return &Ban{
+		hub: hub,
+		log: log,
+	}
+}
+
+// Ban 节点封禁名单：隔离被入侵或行为异常的 agent，封禁期间不允许接入，也不需要删除节点记录。
+type Ban struct {
+	hub mlink.Linker
+	log *slog.Logger
+}
+
+func (bn *Ban) List(ctx context.Context) ([]*mlink.Ban, error) {
+	return bn.hub.Bans(ctx)
+}
+
+func (bn *Ban) Create(ctx context.Context, req *mrequest.MinionBan) error {
+	du := time.Duration(req.Duration) * time.Second
+	b := &mlink.Ban{MinionID: req.MinionID, Inet: req.Inet, Reason: req.Reason}
+	if err := bn.hub.Ban(ctx, b, du); err != nil {
+		return err
+	}
+	bn.log.Warn("封禁节点", slog.Int64("minion_id", req.MinionID), slog.String("inet", req.Inet),
+		slog.String("reason", req.Reason), slog.Duration("duration", du))
+
+	return nil
+}
+
+func (bn *Ban) Lift(ctx context.Context, req *mrequest.MinionUnban) error {
+	if err := bn.hub.Unban(ctx, req.MinionID, req.Inet); err != nil {
+		return err
+	}
+	bn.log.Warn("解除节点封禁", slog.Int64("minion_id", req.MinionID), slog.String("inet", req.Inet))
+
+	return nil
+}
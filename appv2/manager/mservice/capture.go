@@ -0,0 +1,72 @@
+package mservice
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
+)
+
+func NewCapture(hub mlink.Linker, log *slog.Logger) *Capture {
+	return &Capture{
+		hub: hub,
+		log: log,
+	}
+}
+
+// Capture 节点流量抓包：排查 agent 异常时按需开启，记录节点与 broker 之间的 HTTP 请求。
+type Capture struct {
+	hub mlink.Linker
+	log *slog.Logger
+}
+
+func (cpt *Capture) List() []*mlink.CaptureStat {
+	return cpt.hub.Captures()
+}
+
+func (cpt *Capture) Start(req *mrequest.MinionCapture) (*mlink.CaptureStat, error) {
+	opt := mlink.CaptureOption{
+		MinionID: req.MinionID,
+		Duration: req.Duration,
+		MaxBody:  req.MaxBody,
+		MaxSize:  req.MaxSize,
+		MaxFiles: req.MaxFiles,
+		Redact:   req.Redact,
+	}
+	ret, err := cpt.hub.Capture(opt)
+	if err != nil {
+		return nil, err
+	}
+	cpt.log.Warn("开启节点抓包", slog.Int64("minion_id", req.MinionID), slog.String("path", ret.Path),
+		slog.Time("expired_at", ret.ExpiredAt))
+
+	return ret, nil
+}
+
+func (cpt *Capture) Stop(req *mrequest.MinionCaptureID) (*mlink.CaptureStat, error) {
+	ret, err := cpt.hub.Uncapture(req.MinionID)
+	if err != nil {
+		return nil, err
+	}
+	cpt.log.Warn("停止节点抓包", slog.Int64("minion_id", req.MinionID), slog.Int64("entries", ret.Entries))
+
+	return ret, nil
+}
+
+// Files 节点现有的抓包文件，包括滚动后的旧文件。
+func (cpt *Capture) Files(req *mrequest.MinionCaptureID) []*mlink.CaptureFile {
+	return mlink.CaptureFiles(req.MinionID)
+}
+
+// Open 打开节点的抓包文件，抓包停止后文件仍然保留。
+func (cpt *Capture) Open(req *mrequest.MinionCaptureFile) (io.ReadCloser, string, error) {
+	path := mlink.CapturePath(req.MinionID, req.Index)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return file, path, nil
+}
@@ -0,0 +1,50 @@
+package mservice
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
+)
+
+func NewConnect(hub mlink.Linker, gw gateway.Handler) *Connect {
+	return &Connect{hub: hub, gw: gw}
+}
+
+type Connect struct {
+	hub mlink.Linker
+	gw  gateway.Handler
+}
+
+// Throttle 节点接入网关的限流状态。
+func (cnt *Connect) Throttle() gateway.ThrottleStat {
+	return cnt.gw.Throttle()
+}
+
+// Select 根据条件筛选在线节点的 ID，按照节点 ID 排序。
+func (cnt *Connect) Select(f *mlink.Filter) []int64 {
+	ret := cnt.hub.Select(*f)
+	slices.Sort(ret)
+
+	return ret
+}
+
+// Stats 查询在线节点的连接统计信息，按照节点 ID 排序。
+func (cnt *Connect) Stats(req *mrequest.ConnectFilter) []mlink.ConnStat {
+	stats := cnt.hub.Stats()
+	ret := make([]mlink.ConnStat, 0, len(stats))
+	for _, st := range stats {
+		if (req.ID != 0 && st.ID != req.ID) ||
+			(req.Inet != "" && st.Inet != req.Inet) ||
+			(req.Goos != "" && st.Goos != req.Goos) ||
+			(req.Semver != "" && st.Semver != req.Semver) {
+			continue
+		}
+		ret = append(ret, st)
+	}
+	slices.SortFunc(ret, func(a, b mlink.ConnStat) int { return cmp.Compare(a.ID, b.ID) })
+
+	return ret
+}
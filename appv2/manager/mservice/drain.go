@@ -0,0 +1,153 @@
+package mservice
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/appv2/manager/mrequest"
+	"github.com/vela-ssoc/ssoc-broker/bridge/mlink"
+	"github.com/vela-ssoc/ssoc-common-mb/accord"
+)
+
+func NewDrain(hub mlink.Linker, sys *System, log *slog.Logger) *Drain {
+	return &Drain{
+		hub: hub,
+		sys: sys,
+		log: log,
+	}
+}
+
+// Drain 平滑下线：不再接纳新节点，分批通知已连接的节点重连到指定的 broker，
+// 待节点全部断开或超时后退出程序，避免所有节点在 broker 退出的瞬间同时重连。
+type Drain struct {
+	hub    mlink.Linker
+	sys    *System
+	log    *slog.Logger
+	mutex  sync.Mutex
+	status mrequest.DrainStatus
+}
+
+func (drn *Drain) Start(req *mrequest.SystemDrain) error {
+	batch := req.Batch
+	if batch <= 0 {
+		batch = 50
+	}
+	interval := time.Duration(req.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Duration(req.Deadline) * time.Second
+	if deadline <= 0 {
+		deadline = 10 * time.Minute
+	}
+
+	now := time.Now()
+	ids := drn.hub.ConnectIDs()
+	drn.mutex.Lock()
+	if drn.status.Draining {
+		drn.mutex.Unlock()
+		return errors.New("broker 已经处于排空状态")
+	}
+	drn.status = mrequest.DrainStatus{
+		Draining:  true,
+		Broker:    req.Broker,
+		Total:     len(ids),
+		StartedAt: now,
+		Deadline:  now.Add(deadline),
+	}
+	drn.mutex.Unlock()
+
+	drn.hub.Drain()
+	drn.log.Warn("开始排空节点", slog.String("broker", req.Broker), slog.Any("addrs", req.Addrs),
+		slog.Int("total", len(ids)), slog.Int("batch", batch), slog.Duration("interval", interval))
+
+	cmd := &drainCommand{Command: accord.Command{Cmd: "restart"}, Broker: req.Broker, Addrs: req.Addrs}
+	go drn.run(ids, cmd, batch, interval, now.Add(deadline))
+
+	return nil
+}
+
+func (drn *Drain) Status() mrequest.DrainStatus {
+	drn.mutex.Lock()
+	status := drn.status
+	drn.mutex.Unlock()
+	if status.Draining {
+		status.Remain = len(drn.hub.ConnectIDs())
+	}
+
+	return status
+}
+
+func (drn *Drain) run(ids []int64, cmd *drainCommand, batch int, interval time.Duration, deadline time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	for i := 0; i < len(ids) && ctx.Err() == nil; i += batch {
+		if i != 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+
+		// 同一批节点并发通知，单个节点响应慢不会拖慢整批。
+		var notified, failed atomic.Int64
+		var wg sync.WaitGroup
+		for _, id := range ids[i:min(i+batch, len(ids))] {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := drn.notify(ctx, id, cmd); err != nil {
+					failed.Add(1)
+					drn.log.Warn("通知节点迁移失败", slog.Int64("minion_id", id), slog.Any("error", err))
+				} else {
+					notified.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		drn.mutex.Lock()
+		drn.status.Notified += int(notified.Load())
+		drn.status.Failed += int(failed.Load())
+		drn.mutex.Unlock()
+	}
+
+	// 等待节点全部断开
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for len(drn.hub.ConnectIDs()) != 0 {
+		select {
+		case <-ctx.Done():
+			drn.log.Warn("排空超时，仍有节点在线", slog.Int("remain", len(drn.hub.ConnectIDs())))
+			drn.sys.Exit()
+			return
+		case <-ticker.C:
+		}
+	}
+
+	drn.log.Warn("节点已全部迁移完毕")
+	drn.sys.Exit()
+}
+
+// drainCommand 通知 agent 迁移的命令：在 restart 命令的基础上携带目标 broker 的地址，
+// agent 重启后改为连接这些地址。不认识这两个字段的旧版本 agent 会按照 restart 处理，
+// 重连时被本 broker 拒绝（503）后按照自身的地址列表连接其它 broker。
+type drainCommand struct {
+	accord.Command
+	Broker string   `json:"broker"`
+	Addrs  []string `json:"addrs"`
+}
+
+// notify 通过 agent 的命令接口通知节点迁移。
+func (drn *Drain) notify(parent context.Context, id int64, cmd *drainCommand) error {
+	const rawURL = "/api/v1/agent/notice/command"
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	defer cancel()
+
+	return drn.hub.Oneway(ctx, id, rawURL, cmd)
+}
@@ -9,7 +9,8 @@ import (
 
 // Ident minion 节点握手认证时需要携带的信息，
 type Ident struct {
-	Inet       net.IP        `json:"inet"`       // 内网出口 IP
+	Inet       net.IP        `json:"inet"`       // 内网出口 IP，IPv6 单栈主机为 IPv6 地址
+	Inet6      net.IP        `json:"inet6"`      // 出口网卡的 IPv6 地址，没有可为空
 	MAC        string        `json:"mac"`        // 出口 IP 所在网卡的 MAC 地址
 	CPU        int           `json:"cpu"`        // CPU 核心数
 	PID        int           `json:"pid"`        // 进程 PID
@@ -25,9 +26,39 @@ type Ident struct {
 	Unload     bool          `json:"unload"`     // 是否开启静默模式，仅在新注册节点时有效
 	Unstable   bool          `json:"unstable"`   // 不稳定版本
 	Customized string        `json:"customized"` // 定制版本
+	HostID     string        `json:"host_id"`    // 主机唯一标识（如 machine-id），旧版本 agent 可能为空
 }
 
 // Decrypt 认证身份信息解密
 func (ide *Ident) Decrypt(enc []byte) error {
 	return ciphertext.DecryptJSON(enc, ide)
 }
+
+// Inets 返回规范化后的节点地址：inet 优先使用 IPv4 地址，IPv6 单栈主机使用 IPv6 地址；
+// inet6 为节点的 IPv6 地址，没有时为空。inet 为空说明节点没有可用的地址。
+//
+// 回环、未指定、组播地址以及 IPv6 链路本地地址（不同主机间可能重复）均视为不可用。
+func (ide Ident) Inets() (inet, inet6 string) {
+	if ip := ide.Inet.To4(); usable(ip) {
+		inet = ip.String()
+	}
+	for _, ip := range []net.IP{ide.Inet6, ide.Inet} {
+		if ip.To4() == nil && usable(ip) {
+			inet6 = ip.String()
+			break
+		}
+	}
+	if inet == "" {
+		inet = inet6
+	}
+
+	return inet, inet6
+}
+
+func usable(ip net.IP) bool {
+	if len(ip) == 0 || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+
+	return ip.To4() != nil || !ip.IsLinkLocalUnicast()
+}
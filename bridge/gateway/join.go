@@ -3,13 +3,17 @@ package gateway
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
 
 	"github.com/vela-ssoc/ssoc-common-mb/problem"
-	"golang.org/x/time/rate"
 )
 
 type Joiner interface {
@@ -18,23 +22,57 @@ type Joiner interface {
 	Join(context.Context, net.Conn, Ident, Issue) error
 }
 
-func New(joiner Joiner) http.Handler {
-	maxsize := 150
-	throughput := rate.NewLimiter(rate.Limit(maxsize), maxsize)
+// StatusCoder 可选接口，Auth 返回的错误实现该接口时，网关使用该状态码响应，
+// 方便 agent 区分不同的拒绝原因（如：封禁）。
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// Admitter 可选接口，Joiner 实现该接口后，网关在鉴权前检查节点的来源地址（TCP 对端地址）
+// 和节点声明的身份信息是否满足准入策略。
+type Admitter interface {
+	Admit(ctx context.Context, peer netip.Addr, ident Ident) error
+}
+
+type peerCtxKey struct{}
+
+// Peer 节点的来源地址（TCP 对端地址），供 Joiner 在 Auth 中使用，取不到时返回零值。
+func Peer(ctx context.Context) netip.Addr {
+	addr, _ := ctx.Value(peerCtxKey{}).(netip.Addr)
+	return addr.Unmap()
+}
+
+// Drainer 可选接口，Joiner 实现该接口后，处于排空状态时网关不再接纳新的节点。
+type Drainer interface {
+	Draining() bool
+}
+
+// Handler 节点接入网关。
+type Handler interface {
+	http.Handler
+
+	// Throttle 接入限流的运行状态。
+	Throttle() ThrottleStat
+}
 
+func New(joiner Joiner) Handler {
 	return &minionGateway{
 		name:       joiner.Name(),
 		joiner:     joiner,
-		throughput: throughput,
+		throughput: newThrottle(),
 	}
 }
 
 type minionGateway struct {
 	name   string
 	joiner Joiner
-	// throughput 限流器，防止 broker 上下线引起的
+	// throughput 自适应限流器，防止 broker 上下线引起的
 	// agent 节点蜂涌重连，拖慢数据库。
-	throughput *rate.Limiter
+	throughput *throttle
+}
+
+func (gate *minionGateway) Throttle() ThrottleStat {
+	return gate.throughput.stat()
 }
 
 func (gate *minionGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +82,17 @@ func (gate *minionGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !gate.throughput.Allow() {
+	if d, ok := gate.joiner.(Drainer); ok && d.Draining() {
+		// 随机 30-60s，防止被拒绝的节点在同一时刻重试。
+		sec := 30 + rand.IntN(31)
+		w.Header().Set("Retry-After", strconv.Itoa(sec))
+		gate.writeError(w, r, http.StatusServiceUnavailable, "broker 正在下线，请连接其它 broker。")
+		return
+	}
+
+	if ok, wait := gate.throughput.allow(); !ok {
+		sec := int64((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(sec, 10))
 		gate.writeError(w, r, http.StatusTooManyRequests, "请求过多稍候再试。")
 		return
 	}
@@ -56,15 +104,34 @@ func (gate *minionGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		gate.writeError(w, r, http.StatusBadRequest, "认证信息错误")
 		return
 	}
+	if inet, _ := ident.Inets(); inet == "" {
+		gate.writeError(w, r, http.StatusBadRequest, "节点 IP 不合法")
+		return
+	}
+	peer, _ := netip.ParseAddrPort(r.RemoteAddr)
+	if adm, ok := gate.joiner.(Admitter); ok {
+		if err := adm.Admit(r.Context(), peer.Addr(), ident); err != nil {
+			gate.writeError(w, r, http.StatusForbidden, "准入检查不通过：%s", err.Error())
+			return
+		}
+	}
 
 	// 鉴权
-	ctx := r.Context()
+	ctx := context.WithValue(r.Context(), throttleCtxKey{}, gate.throughput)
+	ctx = context.WithValue(ctx, peerCtxKey{}, peer.Addr())
 	issue, header, forbid, exx := gate.joiner.Auth(ctx, ident)
 	if exx != nil {
 		code := http.StatusBadRequest
 		if forbid {
 			code = http.StatusNotAcceptable
 		}
+		var sc StatusCoder
+		if errors.As(exx, &sc) {
+			code = sc.StatusCode()
+		}
+		for k, vs := range header {
+			w.Header()[k] = vs
+		}
 		gate.writeError(w, r, code, "认证失败：%s", exx.Error())
 		return
 	}
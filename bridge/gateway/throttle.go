@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	throttleInit   = 150                    // 初始每秒允许接入的节点数
+	throttleMin    = 10                     // 每秒允许接入节点数的下限
+	throttleMax    = 1000                   // 每秒允许接入节点数的上限
+	throttleStep   = 10                     // 负载正常时每次调整增加的速率
+	throttleFactor = 0.7                    // 负载过高时每次调整乘以的系数
+	throttleTick   = time.Second            // 速率的调整周期
+	slowLatency    = 500 * time.Millisecond // 数据库操作平均耗时超过该值视为负载过高
+	highErrorRate  = 0.1                    // 数据库操作出错率超过该值视为负载过高
+	maxRetryAfter  = 5 * time.Minute        // Retry-After 的上限
+)
+
+// ThrottleStat 网关接入限流的运行状态。
+type ThrottleStat struct {
+	Rate      float64       `json:"rate"`       // 当前每秒允许接入的节点数
+	Latency   time.Duration `json:"latency"`    // Auth/Join 中数据库操作的平均耗时
+	ErrorRate float64       `json:"error_rate"` // Auth/Join 中数据库操作的出错率
+	Allowed   int64         `json:"allowed"`    // 累计放行的接入请求数
+	Rejected  int64         `json:"rejected"`   // 累计因限流拒绝的接入请求数
+}
+
+// throttle 自适应的接入限流器：根据 Auth/Join 中数据库操作的耗时和出错率动态调整接入速率，
+// 数据库变慢或出错增多时快速降低速率（乘性减），恢复正常后缓慢提高速率（加性增）。
+type throttle struct {
+	limiter  *rate.Limiter
+	allowed  atomic.Int64
+	rejected atomic.Int64
+
+	mutex     sync.Mutex
+	latency   float64   // 耗时的指数移动平均值（纳秒）
+	errorRate float64   // 出错率的指数移动平均值
+	tickAt    time.Time // 上次调整速率的时间
+
+	window   int64     // 本周期内拒绝的请求数
+	windowAt time.Time // 本周期的开始时间
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		limiter:  rate.NewLimiter(throttleInit, throttleInit),
+		tickAt:   time.Now(),
+		windowAt: time.Now(),
+	}
+}
+
+// allow 是否放行本次接入请求，拒绝时返回建议 agent 等待的时间。
+func (th *throttle) allow() (bool, time.Duration) {
+	if th.limiter.Allow() {
+		th.allowed.Add(1)
+		return true, 0
+	}
+	th.rejected.Add(1)
+
+	now := time.Now()
+	th.mutex.Lock()
+	if now.Sub(th.windowAt) >= throttleTick {
+		th.window, th.windowAt = 0, now
+	}
+	th.window++
+	backlog := th.window
+	th.mutex.Unlock()
+
+	// 按照当前速率处理完本周期内被拒绝的请求大概需要的时间，
+	// 在此基础上增加 0-100% 的随机抖动，打散 agent 的重连时间。
+	limit := float64(th.limiter.Limit())
+	wait := time.Duration(float64(backlog) / limit * float64(time.Second))
+	wait = max(wait, time.Second)
+	wait += time.Duration(rand.Int64N(int64(wait)))
+
+	return false, min(wait, maxRetryAfter)
+}
+
+// observe 记录一次数据库操作的耗时和结果，并定期调整接入速率。
+func (th *throttle) observe(du time.Duration, failed bool) {
+	const alpha = 0.2
+
+	var fail float64
+	if failed {
+		fail = 1
+	}
+
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	if th.latency == 0 {
+		th.latency = float64(du)
+	} else {
+		th.latency = alpha*float64(du) + (1-alpha)*th.latency
+	}
+	th.errorRate = alpha*fail + (1-alpha)*th.errorRate
+
+	now := time.Now()
+	if now.Sub(th.tickAt) < throttleTick {
+		return
+	}
+
+	limit := float64(th.limiter.Limit())
+	if time.Duration(th.latency) > slowLatency || th.errorRate > highErrorRate {
+		limit = math.Max(limit*throttleFactor, throttleMin)
+	} else if th.window > 0 && now.Sub(th.windowAt) < 2*throttleTick { // 近期有请求被拒绝且负载正常时才提高速率
+		limit = math.Min(limit+throttleStep, throttleMax)
+	}
+	th.limiter.SetLimitAt(now, rate.Limit(limit))
+	th.limiter.SetBurstAt(now, int(limit))
+	th.tickAt = now
+}
+
+func (th *throttle) stat() ThrottleStat {
+	th.mutex.Lock()
+	latency, errorRate := th.latency, th.errorRate
+	th.mutex.Unlock()
+
+	return ThrottleStat{
+		Rate:      float64(th.limiter.Limit()),
+		Latency:   time.Duration(latency),
+		ErrorRate: errorRate,
+		Allowed:   th.allowed.Load(),
+		Rejected:  th.rejected.Load(),
+	}
+}
+
+type throttleCtxKey struct{}
+
+// Observe 供 Joiner 在 Auth/Join 中上报数据库操作的耗时和结果，网关据此调整接入速率。
+// 只应上报数据库等基础设施的错误，节点重复登录、封禁等业务上的拒绝不应视为出错。
+func Observe(ctx context.Context, du time.Duration, err error) {
+	if th, _ := ctx.Value(throttleCtxKey{}).(*throttle); th != nil {
+		th.observe(du, err != nil)
+	}
+}
@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestThrottleObserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    float64
+		du       time.Duration
+		failed   bool
+		rejected bool // 近期是否有请求被拒绝
+		tick     bool // 是否到了调整周期
+		want     float64
+	}{
+		{"未到调整周期", 150, time.Second, false, true, false, 150},
+		{"负载正常且没有拒绝", 150, 10 * time.Millisecond, false, false, true, 150},
+		{"负载正常且近期有拒绝", 150, 10 * time.Millisecond, false, true, true, 160},
+		{"不超过上限", throttleMax, 10 * time.Millisecond, false, true, true, throttleMax},
+		{"耗时过高", 150, time.Second, false, true, true, 105},
+		{"出错率过高", 150, 10 * time.Millisecond, true, false, true, 105},
+		{"不低于下限", throttleMin + 2, time.Second, false, false, true, throttleMin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			th := newThrottle()
+			th.limiter.SetLimit(rate.Limit(tt.limit))
+			if tt.tick {
+				th.tickAt = now.Add(-2 * throttleTick)
+			}
+			if tt.rejected {
+				th.window, th.windowAt = 3, now
+			}
+
+			th.observe(tt.du, tt.failed)
+			if got := th.stat().Rate; got != tt.want {
+				t.Errorf("rate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottleAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   float64
+		backlog int64 // 本周期内已经拒绝的请求数
+		lo, hi  time.Duration
+	}{
+		{"至少等待 1s", 100, 0, time.Second, 2 * time.Second},
+		{"按照积压估算", 10, 49, 5 * time.Second, 10 * time.Second},
+		{"不超过上限", 10, 9999, maxRetryAfter, maxRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newThrottle()
+			th.limiter = rate.NewLimiter(rate.Limit(tt.limit), 0) // burst 为 0 时总是拒绝
+			th.window, th.windowAt = tt.backlog, time.Now()
+
+			ok, wait := th.allow()
+			if ok {
+				t.Fatal("应该被拒绝")
+			}
+			if wait < tt.lo || wait > tt.hi {
+				t.Errorf("wait = %s, want [%s, %s]", wait, tt.lo, tt.hi)
+			}
+			if st := th.stat(); st.Rejected != 1 || st.Allowed != 0 {
+				t.Errorf("stat = %+v", st)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package mlink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+	"gorm.io/gorm"
+)
+
+// admissionKey 节点准入策略的配置项，value 为 AdmissionConfig 的 JSON。
+const admissionKey = "minion.admission"
+
+// 节点声明的 inet 与实际来源地址不一致时的处理方式
+const (
+	MismatchIgnore = "ignore" // 忽略
+	MismatchAlert  = "alert"  // 允许接入，但产生告警事件（默认）
+	MismatchReject = "reject" // 拒绝接入
+)
+
+// AdmissionConfig 节点准入策略，由中心端写入 broker 运行时配置。
+//
+// 节点的来源地址（TCP 对端地址）和节点声明的 inet 都要满足策略才允许接入。
+type AdmissionConfig struct {
+	Allow    []string `json:"allow"`    // 允许的网段（CIDR 或单个 IP），为空代表不限制
+	Deny     []string `json:"deny"`     // 拒绝的网段（CIDR 或单个 IP），优先级高于 Allow
+	Mismatch string   `json:"mismatch"` // 声明的 inet 与来源地址不一致时的处理方式
+}
+
+// AdmissionPolicy 解析后生效中的准入策略。
+type AdmissionPolicy struct {
+	Allow    []netip.Prefix `json:"allow"`
+	Deny     []netip.Prefix `json:"deny"`
+	Mismatch string         `json:"mismatch"`
+	Invalids []string       `json:"invalids,omitempty"` // 无法解析的配置项，已被忽略
+	Error    string         `json:"error,omitempty"`    // 加载出错且没有可沿用的策略时的错误，此时拒绝所有节点接入
+	LoadedAt time.Time      `json:"loaded_at"`
+}
+
+const (
+	admissionTTL      = time.Minute      // 策略的缓存时间
+	admissionRetry    = 10 * time.Second // 加载出错时的重试间隔
+	admissionNotified = 10 * time.Minute // 同一来源的拒绝、不一致事件在该时间内只产生一次
+)
+
+// admission 准入策略的缓存。
+type admission struct {
+	mutex  sync.Mutex
+	policy *AdmissionPolicy
+
+	seenMu sync.Mutex
+	seen   map[string]time.Time // 已经产生过事件的来源，用于事件去重
+}
+
+// notify 同一个 key 在 admissionNotified 时间内只返回一次 true，
+// 防止 NAT 后的节点每次重连、被拒绝的节点反复重试时都产生事件。
+func (adm *admission) notify(key string, now time.Time) bool {
+	adm.seenMu.Lock()
+	defer adm.seenMu.Unlock()
+
+	if adm.seen == nil {
+		adm.seen = make(map[string]time.Time, 64)
+	}
+	if at, ok := adm.seen[key]; ok && now.Sub(at) < admissionNotified {
+		return false
+	}
+	if len(adm.seen) >= 4096 {
+		for k, at := range adm.seen {
+			if now.Sub(at) >= admissionNotified {
+				delete(adm.seen, k)
+			}
+		}
+	}
+	adm.seen[key] = now
+
+	return true
+}
+
+func parsePrefixes(strs []string) ([]netip.Prefix, []string) {
+	var ret []netip.Prefix
+	var invalids []string
+	for _, str := range strs {
+		str = strings.TrimSpace(str)
+		if pfx, err := netip.ParsePrefix(str); err == nil {
+			ret = append(ret, pfx.Masked())
+		} else if addr, exx := netip.ParseAddr(str); exx == nil {
+			addr = addr.Unmap()
+			ret = append(ret, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			invalids = append(invalids, str)
+		}
+	}
+
+	return ret, invalids
+}
+
+func containsAddr(pfxs []netip.Prefix, addr netip.Addr) bool {
+	for _, pfx := range pfxs {
+		if pfx.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// permit 检查地址是否满足策略，不满足时返回原因。
+func (ap *AdmissionPolicy) permit(addr netip.Addr) string {
+	if containsAddr(ap.Deny, addr) {
+		return "命中拒绝名单"
+	}
+	if len(ap.Allow) != 0 && !containsAddr(ap.Allow, addr) {
+		return "不在允许名单中"
+	}
+	return ""
+}
+
+// Admission 当前生效的准入策略，reload 为 true 时立即从数据库重新加载，
+// 否则使用缓存，缓存一分钟后自动重新加载。
+func (hub *minionHub) Admission(ctx context.Context, reload bool) *AdmissionPolicy {
+	adm := &hub.admission
+	adm.mutex.Lock()
+	defer adm.mutex.Unlock()
+
+	now := time.Now()
+	if ap := adm.policy; !reload && ap != nil {
+		ttl := admissionTTL
+		if ap.Error != "" {
+			ttl = admissionRetry
+		}
+		if now.Before(ap.LoadedAt.Add(ttl)) {
+			return ap
+		}
+	}
+
+	var cfg AdmissionConfig
+	err := hub.runtimeConfig(ctx, admissionKey, &cfg)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		// 数据库暂时不可用时继续沿用原有的策略，没有可沿用的策略时拒绝所有节点接入，不要因此放开限制。
+		// 已经返回给调用方的策略不能修改，复制一份再更新加载时间。
+		var ap AdmissionPolicy
+		if old := adm.policy; old != nil && old.Error == "" {
+			hub.log.Warn(fmt.Sprintf("加载节点准入策略出错，继续使用原有策略：%v", err))
+			ap = *old
+		} else {
+			hub.log.Error(fmt.Sprintf("加载节点准入策略出错，暂时拒绝所有节点接入：%v", err))
+			ap = AdmissionPolicy{Mismatch: MismatchAlert, Error: err.Error()}
+		}
+		ap.LoadedAt = now
+		adm.policy = &ap
+		return &ap
+	}
+
+	allows, invalids := parsePrefixes(cfg.Allow)
+	denies, others := parsePrefixes(cfg.Deny)
+	ap := &AdmissionPolicy{
+		Allow:    allows,
+		Deny:     denies,
+		Mismatch: cfg.Mismatch,
+		Invalids: append(invalids, others...),
+		LoadedAt: now,
+	}
+	switch ap.Mismatch {
+	case MismatchIgnore, MismatchAlert, MismatchReject:
+	default:
+		ap.Mismatch = MismatchAlert
+	}
+	if len(ap.Invalids) != 0 {
+		hub.log.Warn(fmt.Sprintf("节点准入策略中存在无法解析的网段，已忽略：%s", strings.Join(ap.Invalids, ",")))
+	}
+	adm.policy = ap
+
+	return ap
+}
+
+// Admit 节点鉴权前检查来源地址和声明的 inet 是否满足准入策略，
+// 拒绝和地址不一致的事件按照来源去重后异步产生。
+func (hub *minionHub) Admit(ctx context.Context, peer netip.Addr, ident gateway.Ident) error {
+	ap := hub.Admission(ctx, false)
+	peer = peer.Unmap()
+	inet, inet6 := ident.Inets()
+	now := time.Now()
+
+	reason := ap.permit(peer)
+	if ap.Error != "" {
+		reason = "准入策略加载失败，暂时拒绝接入"
+	} else if reason != "" {
+		reason = "来源地址 " + peer.String() + " " + reason
+	} else {
+		for _, s := range []string{inet, inet6} {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				continue
+			}
+			if why := ap.permit(addr.Unmap()); why != "" {
+				reason = "节点声明的地址 " + s + " " + why
+				break
+			}
+		}
+	}
+
+	mismatch := peer.IsValid() && peer.String() != inet && peer.String() != inet6
+	if reason == "" && mismatch && ap.Mismatch != MismatchIgnore {
+		if ap.Mismatch == MismatchReject {
+			reason = fmt.Sprintf("节点声明的地址 %s 与来源地址 %s 不一致", inet, peer)
+		} else if hub.admission.notify("mismatch/"+peer.String()+"/"+inet, now) {
+			hub.phase.Mismatched(ident, peer.String(), now)
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+
+	hub.log.Warn(fmt.Sprintf("节点 %s 未通过准入检查：%s", inet, reason))
+	if hub.admission.notify("refused/"+peer.String()+"/"+inet, now) {
+		hub.phase.Refused(ident, peer.String(), reason, now)
+	}
+
+	return errors.New(reason)
+}
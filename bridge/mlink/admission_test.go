@@ -0,0 +1,77 @@
+package mlink
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	pfxs, invalids := parsePrefixes([]string{"10.0.0.0/8", " 192.168.1.7 ", "2001:db8::/32", "10.1.2.3/8", "bad", "::ffff:1.2.3.4"})
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("1.2.3.4/32"),
+	}
+	if !slices.Equal(pfxs, want) {
+		t.Errorf("prefixes = %v, want %v", pfxs, want)
+	}
+	if !slices.Equal(invalids, []string{"bad"}) {
+		t.Errorf("invalids = %v, want [bad]", invalids)
+	}
+}
+
+func TestAdmissionPermit(t *testing.T) {
+	policy := func(allow, deny []string) *AdmissionPolicy {
+		allows, _ := parsePrefixes(allow)
+		denies, _ := parsePrefixes(deny)
+		return &AdmissionPolicy{Allow: allows, Deny: denies}
+	}
+
+	tests := []struct {
+		name   string
+		policy *AdmissionPolicy
+		addr   string
+		permit bool
+	}{
+		{"没有配置时不限制", policy(nil, nil), "8.8.8.8", true},
+		{"在允许名单中", policy([]string{"10.0.0.0/8"}, nil), "10.1.2.3", true},
+		{"不在允许名单中", policy([]string{"10.0.0.0/8"}, nil), "11.1.2.3", false},
+		{"命中拒绝名单", policy(nil, []string{"10.0.0.0/8"}), "10.1.2.3", false},
+		{"拒绝名单优先", policy([]string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}), "10.1.2.3", false},
+		{"允许名单中未被拒绝的地址", policy([]string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}), "10.2.0.1", true},
+		{"单个 IP", policy([]string{"192.168.1.7"}, nil), "192.168.1.8", false},
+		{"IPv6", policy([]string{"2001:db8::/32"}, nil), "2001:db8::1", true},
+		{"IPv4 名单不匹配 IPv6", policy([]string{"10.0.0.0/8"}, nil), "2001:db8::1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := tt.policy.permit(netip.MustParseAddr(tt.addr))
+			if (reason == "") != tt.permit {
+				t.Errorf("permit(%s) = %q, want permit %v", tt.addr, reason, tt.permit)
+			}
+		})
+	}
+}
+
+func TestAdmissionNotify(t *testing.T) {
+	var adm admission
+	now := time.Now()
+
+	if !adm.notify("refused/1.1.1.1", now) {
+		t.Fatal("第一次应该产生事件")
+	}
+	if adm.notify("refused/1.1.1.1", now.Add(time.Minute)) {
+		t.Fatal("去重时间内不应该重复产生事件")
+	}
+	if !adm.notify("refused/2.2.2.2", now.Add(time.Minute)) {
+		t.Fatal("不同的来源应该产生事件")
+	}
+	if !adm.notify("refused/1.1.1.1", now.Add(admissionNotified)) {
+		t.Fatal("超过去重时间后应该再次产生事件")
+	}
+}
@@ -0,0 +1,296 @@
+package mlink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// arrKey ARR 转发的配置项，value 为 ARRConfig 的 JSON。
+const arrKey = "minion.arr"
+
+// ArrTimeoutHeader 调用方可以通过该请求头覆盖单次请求的超时时间，如：30s、2m，也可以是秒数。
+const ArrTimeoutHeader = "X-Arr-Timeout"
+
+var (
+	ErrBreakerOpen = errors.New("节点连续请求失败，已熔断")
+	ErrArrTimeout  = errors.New("节点响应超时")
+)
+
+// ARRConfig ARR 转发的超时、重试和熔断配置，由中心端写入 broker 运行时配置。
+type ARRConfig struct {
+	Timeout  int `json:"timeout"`  // 默认的请求超时时间（秒），默认 60 秒
+	Retries  int `json:"retries"`  // 幂等请求失败时的重试次数，默认 2 次
+	Failures int `json:"failures"` // 节点连续失败多少次后熔断，默认 5 次
+	Cooldown int `json:"cooldown"` // 熔断持续时间（秒），到期后放行一个探测请求，默认 30 秒
+}
+
+const arrMaxTimeout = 10 * time.Minute // 请求头覆盖超时时间的上限
+
+// arrTransport 在节点 stream 之上增加超时、重试和按节点熔断的 RoundTripper，供 ARR 转发使用。
+type arrTransport struct {
+	hub  *minionHub
+	trip http.RoundTripper
+
+	cfgMutex sync.Mutex
+	config   ARRConfig
+	readAt   time.Time
+
+	mutex    sync.Mutex
+	breakers map[string]*breaker
+}
+
+// breaker 单个节点的熔断状态。
+type breaker struct {
+	failures int       // 连续失败的次数
+	failedAt time.Time // 最近一次失败的时间
+	openAt   time.Time // 熔断开始时间，零值代表未熔断
+	probing  bool      // 熔断到期后是否已经放行了探测请求
+}
+
+// BreakerError 节点熔断时的错误，After 为距离下次探测的时间。
+type BreakerError struct {
+	After time.Duration
+}
+
+func (e *BreakerError) Error() string        { return ErrBreakerOpen.Error() }
+func (e *BreakerError) Is(target error) bool { return target == ErrBreakerOpen }
+
+func newArrTransport(hub *minionHub, trip http.RoundTripper) *arrTransport {
+	return &arrTransport{
+		hub:      hub,
+		trip:     trip,
+		breakers: make(map[string]*breaker, 64),
+	}
+}
+
+func (at *arrTransport) arrConfig(ctx context.Context) ARRConfig {
+	at.cfgMutex.Lock()
+	defer at.cfgMutex.Unlock()
+
+	now := time.Now()
+	if now.Before(at.readAt.Add(time.Minute)) {
+		return at.config
+	}
+
+	var cfg ARRConfig
+	_ = at.hub.runtimeConfig(ctx, arrKey, &cfg)
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 60
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	} else if cfg.Retries == 0 {
+		cfg.Retries = 2
+	}
+	if cfg.Failures <= 0 {
+		cfg.Failures = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30
+	}
+	at.config, at.readAt = cfg, now
+	at.sweep(cfg, now)
+
+	return cfg
+}
+
+func (at *arrTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	parent := req.Context()
+	cfg := at.arrConfig(parent)
+	node := req.URL.Host
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if du := parseArrTimeout(req.Header.Get(ArrTimeoutHeader)); du > 0 {
+		timeout = min(du, arrMaxTimeout)
+	}
+	req.Header.Del(ArrTimeoutHeader)
+
+	// 超时只限制到收到响应头为止，流式输出、大文件下载等读取响应 Body 的时间不受限制。
+	ctx, cancel := context.WithCancel(parent)
+	var expired atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		expired.Store(true)
+		cancel()
+	})
+	req = req.WithContext(ctx)
+
+	// 只有幂等且没有请求体的请求才能安全的重试
+	attempts := 1
+	if idempotent(req.Method) && (req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0) {
+		attempts += cfg.Retries
+	}
+
+	var res *http.Response
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(i) * 200 * time.Millisecond):
+			}
+			if err = ctx.Err(); err != nil {
+				break
+			}
+		}
+		if err = at.allow(node, cfg); err != nil {
+			break
+		}
+
+		res, err = at.trip.RoundTrip(req)
+		if errors.Is(err, ErrMinionOffline) {
+			at.forget(node) // 节点不在线，熔断状态没有意义，也不再重试
+			break
+		}
+		if err != nil && parent.Err() != nil {
+			at.record(node, cfg, arrIgnored) // 调用方取消了请求，与节点无关
+			break
+		}
+		if err == nil && !retryable(res.StatusCode) {
+			at.record(node, cfg, arrSuccess)
+			break
+		}
+		at.record(node, cfg, arrFailure)
+		if err == nil && i+1 < attempts {
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+			_ = res.Body.Close()
+		}
+	}
+
+	if !timer.Stop() && err == nil { // 收到响应头的同时超时了
+		_ = res.Body.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		cancel()
+		if expired.Load() {
+			err = ErrArrTimeout
+		}
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+
+	return res, nil
+}
+
+// 单次请求对熔断的影响
+const (
+	arrSuccess = iota // 成功，恢复熔断
+	arrFailure        // 节点出错或超时，计入连续失败次数
+	arrIgnored        // 调用方取消等与节点无关的原因，不计入熔断
+)
+
+// allow 熔断检查：熔断期间直接返回错误，到期后只放行一个探测请求。
+func (at *arrTransport) allow(node string, cfg ARRConfig) error {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	b := at.breakers[node]
+	if b == nil || b.openAt.IsZero() {
+		return nil
+	}
+	cooldown := time.Duration(cfg.Cooldown) * time.Second
+	if elapsed := time.Since(b.openAt); elapsed < cooldown || b.probing {
+		return &BreakerError{After: max(cooldown-elapsed, time.Second)}
+	}
+	b.probing = true
+
+	return nil
+}
+
+// record 记录请求结果，连续失败次数达到阈值时熔断，成功后恢复。
+// 每个通过 allow 的请求都必须调用 record 或 forget，否则探测请求的名额不会释放。
+func (at *arrTransport) record(node string, cfg ARRConfig, outcome int) {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	b := at.breakers[node]
+	switch outcome {
+	case arrSuccess:
+		delete(at.breakers, node)
+		return
+	case arrIgnored:
+		if b != nil {
+			b.probing = false // 释放探测名额，由下一个请求继续探测
+		}
+		return
+	}
+
+	now := time.Now()
+	if b == nil {
+		b = new(breaker)
+		at.breakers[node] = b
+	}
+	b.failures++
+	b.failedAt = now
+	if b.probing || b.failures >= cfg.Failures {
+		b.openAt, b.probing = now, false
+	}
+}
+
+// forget 删除节点的熔断状态，节点不在线时调用。
+func (at *arrTransport) forget(node string) {
+	at.mutex.Lock()
+	delete(at.breakers, node)
+	at.mutex.Unlock()
+}
+
+// sweep 清理长时间没有失败记录的熔断状态，防止不再请求的节点一直占用内存。
+func (at *arrTransport) sweep(cfg ARRConfig, now time.Time) {
+	idle := max(10*time.Duration(cfg.Cooldown)*time.Second, 10*time.Minute)
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	for node, b := range at.breakers {
+		if now.Sub(b.failedAt) > idle && !b.probing {
+			delete(at.breakers, node)
+		}
+	}
+}
+
+// parseArrTimeout 解析超时时间，支持 Go 的 duration 格式和秒数。
+func parseArrTimeout(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if du, err := time.ParseDuration(s); err == nil {
+		return du
+	}
+	if sec, err := strconv.Atoi(s); err == nil {
+		return time.Duration(sec) * time.Second
+	}
+	return 0
+}
+
+// idempotent 判断请求方法是否幂等。
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func retryable(code int) bool {
+	return code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// cancelBody 关闭响应 Body 时释放请求的 context。
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (cb *cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
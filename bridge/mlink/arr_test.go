@@ -0,0 +1,143 @@
+package mlink
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestArrBreaker(t *testing.T) {
+	cfg := ARRConfig{Timeout: 60, Retries: 2, Failures: 3, Cooldown: 30}
+	const node = "1"
+
+	tests := []struct {
+		name    string
+		prepare func(at *arrTransport)
+		wantErr bool
+	}{
+		{
+			name:    "没有失败记录",
+			prepare: func(at *arrTransport) {},
+		},
+		{
+			name: "连续失败未达到阈值",
+			prepare: func(at *arrTransport) {
+				at.record(node, cfg, arrFailure)
+				at.record(node, cfg, arrFailure)
+			},
+		},
+		{
+			name: "连续失败达到阈值后熔断",
+			prepare: func(at *arrTransport) {
+				for range cfg.Failures {
+					at.record(node, cfg, arrFailure)
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "成功后重新计数",
+			prepare: func(at *arrTransport) {
+				at.record(node, cfg, arrFailure)
+				at.record(node, cfg, arrFailure)
+				at.record(node, cfg, arrSuccess)
+				at.record(node, cfg, arrFailure)
+			},
+		},
+		{
+			name: "调用方取消不计入失败",
+			prepare: func(at *arrTransport) {
+				for range cfg.Failures {
+					at.record(node, cfg, arrIgnored)
+				}
+			},
+		},
+		{
+			name: "冷却期结束后放行一个探测请求",
+			prepare: func(at *arrTransport) {
+				at.breakers[node] = &breaker{failures: 3, openAt: time.Now().Add(-time.Minute)}
+			},
+		},
+		{
+			name: "探测请求进行中时其它请求仍然熔断",
+			prepare: func(at *arrTransport) {
+				at.breakers[node] = &breaker{failures: 3, openAt: time.Now().Add(-time.Minute)}
+				_ = at.allow(node, cfg)
+			},
+			wantErr: true,
+		},
+		{
+			name: "探测请求失败后重新熔断",
+			prepare: func(at *arrTransport) {
+				at.breakers[node] = &breaker{failures: 3, openAt: time.Now().Add(-time.Minute)}
+				_ = at.allow(node, cfg)
+				at.record(node, cfg, arrFailure)
+			},
+			wantErr: true,
+		},
+		{
+			name: "探测请求被取消后释放探测名额",
+			prepare: func(at *arrTransport) {
+				at.breakers[node] = &breaker{failures: 3, openAt: time.Now().Add(-time.Minute)}
+				_ = at.allow(node, cfg)
+				at.record(node, cfg, arrIgnored)
+			},
+		},
+		{
+			name: "探测时节点离线后清除熔断状态",
+			prepare: func(at *arrTransport) {
+				at.breakers[node] = &breaker{failures: 3, openAt: time.Now().Add(-time.Minute)}
+				_ = at.allow(node, cfg)
+				at.forget(node)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := newArrTransport(nil, nil)
+			tt.prepare(at)
+			err := at.allow(node, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("allow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrBreakerOpen) {
+				t.Fatalf("allow() error = %v, want ErrBreakerOpen", err)
+			}
+		})
+	}
+}
+
+func TestArrSweep(t *testing.T) {
+	cfg := ARRConfig{Cooldown: 30}
+	now := time.Now()
+	at := newArrTransport(nil, nil)
+	at.breakers["stale"] = &breaker{failures: 1, failedAt: now.Add(-time.Hour)}
+	at.breakers["fresh"] = &breaker{failures: 1, failedAt: now}
+	at.breakers["probing"] = &breaker{failures: 5, failedAt: now.Add(-time.Hour), probing: true}
+
+	at.sweep(cfg, now)
+	for node, want := range map[string]bool{"stale": false, "fresh": true, "probing": true} {
+		if _, ok := at.breakers[node]; ok != want {
+			t.Errorf("breaker %s exists = %v, want %v", node, ok, want)
+		}
+	}
+}
+
+func TestParseArrTimeout(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"30s", 30 * time.Second},
+		{"2m", 2 * time.Minute},
+		{"45", 45 * time.Second},
+		{"abc", 0},
+	}
+	for _, tt := range tests {
+		if got := parseArrTimeout(tt.in); got != tt.want {
+			t.Errorf("parseArrTimeout(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
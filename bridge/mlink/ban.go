@@ -0,0 +1,293 @@
+package mlink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+	"github.com/vela-ssoc/ssoc-common-mb/dal/model"
+	"gorm.io/gorm/clause"
+)
+
+// banBucket 节点封禁名单在 kv_data 中使用的 bucket，key 为 id:<节点ID> 或 ip:<节点IP>。
+const banBucket = "ssoc.minion.ban"
+
+const banSweep = 10 * time.Minute // 清理过期封禁记录的周期
+
+// Ban 节点封禁记录，MinionID 和 Inet 二选一。
+type Ban struct {
+	MinionID  int64      `json:"minion_id,omitempty"` // 按照节点 ID 封禁
+	Inet      string     `json:"inet,omitempty"`      // 按照节点 IP 封禁
+	Reason    string     `json:"reason"`              // 封禁原因
+	BannedAt  time.Time  `json:"banned_at"`           // 封禁时间
+	ExpiredAt *time.Time `json:"expired_at"`          // 解封时间，为空代表永久封禁
+}
+
+func (b *Ban) key() string {
+	if b.MinionID != 0 {
+		return banKeyID(b.MinionID)
+	}
+	return banKeyIP(b.Inet)
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return b.ExpiredAt != nil && !now.Before(*b.ExpiredAt)
+}
+
+func banKeyID(id int64) string {
+	return "id:" + strconv.FormatInt(id, 10)
+}
+
+func banKeyIP(inet string) string {
+	if addr, err := netip.ParseAddr(inet); err == nil {
+		inet = addr.Unmap().String()
+	}
+	return "ip:" + inet
+}
+
+// BannedError 节点处于封禁期间拒绝接入的错误。
+type BannedError struct {
+	Ban *Ban
+}
+
+func (e *BannedError) Error() string {
+	b := e.Ban
+	msg := "节点已被封禁"
+	if b.Reason != "" {
+		msg += "：" + b.Reason
+	}
+	if b.ExpiredAt != nil {
+		msg += "，解封时间：" + b.ExpiredAt.Format(time.DateTime)
+	}
+	return msg
+}
+
+// StatusCode 封禁使用 403 状态码，与认证信息错误等区分开，agent 收到后应停止频繁重连。
+func (e *BannedError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// Header 临时封禁时通过 Retry-After 告知 agent 解封时间。
+func (e *BannedError) Header() http.Header {
+	at := e.Ban.ExpiredAt
+	if at == nil {
+		return nil
+	}
+	sec := int64(time.Until(*at)/time.Second) + 1
+
+	return http.Header{"Retry-After": []string{strconv.FormatInt(sec, 10)}}
+}
+
+// banned 查询节点 ID 或 IP 是否处于封禁期间，未封禁时返回 nil。
+func (hub *minionHub) banned(ctx context.Context, ids []int64, inets ...string) (*Ban, error) {
+	keys := make([]string, 0, len(ids)+len(inets))
+	for _, id := range ids {
+		if id != 0 {
+			keys = append(keys, banKeyID(id))
+		}
+	}
+	for _, inet := range inets {
+		if inet != "" {
+			keys = append(keys, banKeyIP(inet))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	tbl := hub.qry.KVData
+	dats, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(banBucket), tbl.Key.In(keys...)).
+		Find()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, dat := range dats {
+		b := new(Ban)
+		if exx := json.Unmarshal(dat.Value, b); exx != nil || b.expired(now) {
+			continue
+		}
+		return b, nil
+	}
+
+	return nil, nil
+}
+
+// Knockout 断开节点连接，ban 大于 0 时同时按照节点 ID 封禁一段时间，封禁期间节点无法重新接入。
+func (hub *minionHub) Knockout(ctx context.Context, mid int64, reason string, ban time.Duration) error {
+	if mid == 0 {
+		return nil
+	}
+	if ban > 0 {
+		return hub.Ban(ctx, &Ban{MinionID: mid, Reason: reason}, ban)
+	}
+
+	id := strconv.FormatInt(mid, 10)
+	if conn := hub.section.Del(id); conn != nil {
+		hub.log.Warn(fmt.Sprintf("断开节点 %s(%d) 的连接：%s", conn.Inet(), mid, reason))
+		_ = conn.mux.Close()
+	}
+
+	return nil
+}
+
+// Ban 封禁节点并断开匹配的在线连接，du 小于等于 0 代表永久封禁，重复封禁会覆盖原有记录。
+func (hub *minionHub) Ban(ctx context.Context, b *Ban, du time.Duration) error {
+	if b.MinionID == 0 && b.Inet == "" {
+		return errors.New("封禁的节点 ID 和 IP 不能同时为空")
+	}
+	if b.MinionID != 0 && b.Inet != "" {
+		return errors.New("封禁的节点 ID 和 IP 只能二选一")
+	}
+
+	now := time.Now()
+	// 永久封禁的记录 kv_data 的过期时间给一个足够大的值，以节点封禁记录中的为准。
+	expiredAt := now.AddDate(100, 0, 0)
+	b.BannedAt, b.ExpiredAt = now, nil
+	if du > 0 {
+		expiredAt = now.Add(du)
+		b.ExpiredAt = &expiredAt
+	}
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	dat := &model.KVData{
+		Bucket:    banBucket,
+		Key:       b.key(),
+		Value:     raw,
+		Lifetime:  du,
+		ExpiredAt: expiredAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	tbl := hub.qry.KVData
+	if err = tbl.WithContext(ctx).
+		Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"value", "lifetime", "expired_at", "updated_at"})}).
+		Create(dat); err != nil {
+		return err
+	}
+
+	for _, c := range hub.section.Conns() {
+		if b.MinionID != 0 && c.id != b.MinionID {
+			continue
+		}
+		if b.Inet != "" {
+			inet, inet6 := c.ident.Inets()
+			key := b.key()
+			if key != banKeyIP(inet) && key != banKeyIP(inet6) && (!c.peer.IsValid() || key != banKeyIP(c.peer.String())) {
+				continue
+			}
+		}
+		hub.log.Warn(fmt.Sprintf("节点 %s(%d) 被封禁，断开连接：%s", c.Inet(), c.id, b.Reason))
+		_ = c.mux.Close()
+	}
+
+	return nil
+}
+
+// Unban 解除节点封禁，同时指定节点 ID 和 IP 时两者的封禁都会解除。
+func (hub *minionHub) Unban(ctx context.Context, mid int64, inet string) error {
+	if mid == 0 && inet == "" {
+		return errors.New("解封的节点 ID 和 IP 不能同时为空")
+	}
+	keys := make([]string, 0, 2)
+	if mid != 0 {
+		keys = append(keys, banKeyID(mid))
+	}
+	if inet != "" {
+		keys = append(keys, banKeyIP(inet))
+	}
+
+	tbl := hub.qry.KVData
+	_, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(banBucket), tbl.Key.In(keys...)).
+		Delete()
+
+	return err
+}
+
+// Bans 查询所有生效中的封禁记录，过期的记录由 sweepBans 定期清理。
+func (hub *minionHub) Bans(ctx context.Context) ([]*Ban, error) {
+	tbl := hub.qry.KVData
+	dats, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(banBucket)).
+		Order(tbl.CreatedAt.Desc()).
+		Find()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ret := make([]*Ban, 0, len(dats))
+	for _, dat := range dats {
+		b := new(Ban)
+		if exx := json.Unmarshal(dat.Value, b); exx != nil || b.expired(now) {
+			continue
+		}
+		ret = append(ret, b)
+	}
+
+	return ret, nil
+}
+
+// sweepBans 周期性的清理过期的封禁记录，parent 结束后退出。
+// 封禁记录在 kv_data 中的过期时间与解封时间一致，多个 broker 同时清理也没有影响。
+func (hub *minionHub) sweepBans(parent context.Context) {
+	ticker := time.NewTicker(banSweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-parent.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(parent, 30*time.Second)
+		tbl := hub.qry.KVData
+		ret, err := tbl.WithContext(ctx).
+			Where(tbl.Bucket.Eq(banBucket), tbl.ExpiredAt.Lte(time.Now())).
+			Delete()
+		cancel()
+		if err != nil {
+			hub.log.Warn(fmt.Sprintf("清理过期的节点封禁记录出错：%v", err))
+		} else if ret.RowsAffected != 0 {
+			hub.log.Info(fmt.Sprintf("清理了 %d 条过期的节点封禁记录", ret.RowsAffected))
+		}
+	}
+}
+
+// banCandidates 节点接入时可能对应的节点 ID，用于在 resolve 之前检查 ID 封禁：
+// 主机 ID 绑定的节点，以及该 IP 下指纹匹配的节点。只读查询，不会新建或修改节点记录。
+func (hub *minionHub) banCandidates(ctx context.Context, ident gateway.Ident, inet string) ([]int64, error) {
+	fp := fingerprint{HostID: ident.HostID, MAC: ident.MAC, Hostname: ident.Hostname}
+	ids := make([]int64, 0, 2)
+	if fp.HostID != "" {
+		if mid := hub.hostBinding(ctx, fp.HostID); mid != 0 {
+			ids = append(ids, mid)
+		}
+	}
+
+	tbl := hub.qry.Minion
+	mons, err := tbl.WithContext(ctx).Where(tbl.Inet.Eq(inet)).Find()
+	if err != nil {
+		return nil, err
+	}
+	for _, mon := range mons {
+		if hub.identity(ctx, mon).same(fp) {
+			ids = append(ids, mon.ID)
+		}
+	}
+
+	return ids, nil
+}
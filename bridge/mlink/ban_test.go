@@ -0,0 +1,68 @@
+package mlink
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBanKey(t *testing.T) {
+	tests := []struct {
+		name string
+		ban  Ban
+		want string
+	}{
+		{"节点 ID", Ban{MinionID: 12}, "id:12"},
+		{"IPv4", Ban{Inet: "10.1.2.3"}, "ip:10.1.2.3"},
+		{"IPv4 映射的 IPv6 地址", Ban{Inet: "::ffff:10.1.2.3"}, "ip:10.1.2.3"},
+		{"IPv6 规范化", Ban{Inet: "2001:DB8:0::1"}, "ip:2001:db8::1"},
+		{"非法地址原样保留", Ban{Inet: "bad"}, "ip:bad"},
+		{"同时指定时以 ID 为准", Ban{MinionID: 7, Inet: "10.1.2.3"}, "id:7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ban.key(); got != tt.want {
+				t.Errorf("key() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBanExpired(t *testing.T) {
+	now := time.Now()
+	before, after := now.Add(-time.Second), now.Add(time.Second)
+
+	tests := []struct {
+		name      string
+		expiredAt *time.Time
+		want      bool
+	}{
+		{"永久封禁", nil, false},
+		{"未到解封时间", &after, false},
+		{"刚好到解封时间", &now, true},
+		{"已过解封时间", &before, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Ban{MinionID: 1, ExpiredAt: tt.expiredAt}
+			if got := b.expired(now); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBannedErrorHeader(t *testing.T) {
+	if h := (&BannedError{Ban: &Ban{MinionID: 1}}).Header(); h != nil {
+		t.Errorf("永久封禁不应该有 Retry-After：%v", h)
+	}
+
+	at := time.Now().Add(90 * time.Second)
+	h := (&BannedError{Ban: &Ban{MinionID: 1, ExpiredAt: &at}}).Header()
+	sec, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || sec < 89 || sec > 91 {
+		t.Errorf("Retry-After = %q, want about 90", h.Get("Retry-After"))
+	}
+}
@@ -0,0 +1,694 @@
+package mlink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// captureDir 抓包文件的存放目录。
+const captureDir = "resources/capture"
+
+// 抓包记录的方向
+const (
+	CaptureInbound  = "inbound"  // 节点发起、broker 处理的请求
+	CaptureOutbound = "outbound" // broker 发起、节点处理的请求
+)
+
+var ErrCaptureNotFound = errors.New("该节点没有进行中的抓包")
+
+// CaptureOption 节点流量抓包的参数。
+type CaptureOption struct {
+	MinionID int64    `json:"minion_id"`
+	Duration int      `json:"duration"`  // 抓包时长（秒），到期后自动停止，默认 600 秒，最长 86400 秒
+	MaxBody  int      `json:"max_body"`  // 单个 Body 最多记录的字节数，默认 64KiB
+	MaxSize  int64    `json:"max_size"`  // 单个文件的大小上限，超过后滚动，默认 32MiB
+	MaxFiles int      `json:"max_files"` // 最多保留的文件数（包括当前文件），默认 5 个，最多 100 个
+	Redact   []string `json:"redact"`    // 额外需要脱敏的 Header、Query 参数和 JSON 字段名
+}
+
+const captureMaxFiles = 100 // 最多保留的抓包文件数
+
+// CaptureStat 进行中的抓包。
+type CaptureStat struct {
+	MinionID  int64     `json:"minion_id"`
+	Path      string    `json:"path"`       // 当前写入的文件
+	Entries   int64     `json:"entries"`    // 已经记录的请求数
+	StartedAt time.Time `json:"started_at"` // 开始时间
+	ExpiredAt time.Time `json:"expired_at"` // 自动停止的时间
+}
+
+// CaptureFile 节点的抓包文件。
+type CaptureFile struct {
+	Index     int       `json:"index"` // 0 为当前文件，滚动后的旧文件依次为 1 2 ...
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CaptureEntry 一次请求响应的记录，抓包文件中每行一个（NDJSON），字段参考了 HAR 的 entry。
+type CaptureEntry struct {
+	MinionID  int64           `json:"minion_id"`
+	Inet      string          `json:"inet"`
+	Direction string          `json:"direction"`  // inbound outbound
+	StartedAt time.Time       `json:"started_at"` // 请求开始时间
+	Duration  time.Duration   `json:"duration"`   // 请求耗时
+	Request   CaptureMessage  `json:"request"`
+	Response  *CaptureMessage `json:"response,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// CaptureMessage 请求或响应报文。
+type CaptureMessage struct {
+	Method    string      `json:"method,omitempty"`
+	URL       string      `json:"url,omitempty"` // path 和 query
+	Status    int         `json:"status,omitempty"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      string      `json:"body,omitempty"`
+	Omitted   bool        `json:"omitted,omitempty"`   // 压缩或二进制的 Body 无法脱敏，不记录内容
+	Truncated bool        `json:"truncated,omitempty"` // Body 是否超过 MaxBody 被截断
+}
+
+// RawBody 记录的 Body。
+func (m *CaptureMessage) RawBody() []byte {
+	return []byte(m.Body)
+}
+
+// setBody 记录 Body，需要在设置 Header 之后调用。
+// 压缩（Content-Encoding）或者不是 UTF-8 文本的 Body 无法脱敏，只标记 Omitted 不记录内容。
+func (m *CaptureMessage) setBody(raw []byte, truncated bool) {
+	m.Truncated = truncated
+	if len(raw) == 0 {
+		return
+	}
+	if ce := m.Header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+		m.Omitted = true
+		return
+	}
+	// 截断时可能切断了最后一个多字节字符
+	for i := 0; truncated && i < utf8.UTFMax-1 && len(raw) != 0 && !utf8.Valid(raw); i++ {
+		raw = raw[:len(raw)-1]
+	}
+	if !utf8.Valid(raw) {
+		m.Omitted = true
+		return
+	}
+	m.Body = string(raw)
+}
+
+// CapturePath 节点抓包文件的路径，index 为 0 时是当前文件，滚动后的旧文件依次为 1 2 ...
+func CapturePath(mid int64, index int) string {
+	name := "minion-" + strconv.FormatInt(mid, 10) + ".ndjson"
+	if index > 0 {
+		name += "." + strconv.Itoa(index)
+	}
+	return filepath.Join(captureDir, name)
+}
+
+// CaptureFiles 节点现有的抓包文件，包括滚动后的旧文件，抓包停止后文件仍然保留。
+func CaptureFiles(mid int64) []*CaptureFile {
+	var ret []*CaptureFile
+	for i := 0; i <= captureMaxFiles; i++ {
+		info, err := os.Stat(CapturePath(mid, i))
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			break
+		}
+		ret = append(ret, &CaptureFile{Index: i, Name: info.Name(), Size: info.Size(), UpdatedAt: info.ModTime()})
+	}
+
+	return ret
+}
+
+// capturer 按节点开启的流量抓包：记录节点与 broker 之间经过 smux stream 的 HTTP 请求，
+// 未开启抓包的节点只多一次 map 查询。websocket 等协议升级的请求不记录。
+type capturer struct {
+	hub      *minionHub
+	log      *slog.Logger
+	mutex    sync.RWMutex
+	sessions map[int64]*captureSession
+}
+
+type captureSession struct {
+	opt       CaptureOption
+	redact    redactor
+	file      *rotateFile
+	mutex     sync.Mutex
+	entries   atomic.Int64
+	timer     *time.Timer
+	startedAt time.Time
+	expiredAt time.Time
+}
+
+func (cs *captureSession) stat() *CaptureStat {
+	return &CaptureStat{
+		MinionID:  cs.opt.MinionID,
+		Path:      cs.file.path,
+		Entries:   cs.entries.Load(),
+		StartedAt: cs.startedAt,
+		ExpiredAt: cs.expiredAt,
+	}
+}
+
+func (cs *captureSession) write(ent *CaptureEntry) {
+	rd := cs.redact
+	ent.Request.URL = rd.url(ent.Request.URL)
+	rd.message(&ent.Request)
+	if ent.Response != nil {
+		rd.message(ent.Response)
+	}
+	raw, err := json.Marshal(ent)
+	if err != nil {
+		return
+	}
+	raw = append(raw, '\n')
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if _, err = cs.file.Write(raw); err == nil {
+		cs.entries.Add(1)
+	}
+}
+
+func (cp *capturer) get(mid int64) *captureSession {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	return cp.sessions[mid]
+}
+
+func (cp *capturer) start(opt CaptureOption) (*CaptureStat, error) {
+	if opt.Duration <= 0 {
+		opt.Duration = 600
+	}
+	opt.Duration = min(opt.Duration, 86400)
+	duration := time.Duration(opt.Duration) * time.Second
+	if opt.MaxBody <= 0 {
+		opt.MaxBody = 64 * 1024
+	}
+	if opt.MaxSize <= 0 {
+		opt.MaxSize = 32 * 1024 * 1024
+	}
+	if opt.MaxFiles <= 0 {
+		opt.MaxFiles = 5
+	}
+	opt.MaxFiles = min(opt.MaxFiles, captureMaxFiles)
+
+	file, err := openRotateFile(CapturePath(opt.MinionID, 0), opt.MaxSize, opt.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sess := &captureSession{
+		opt:       opt,
+		redact:    newRedactor(opt.Redact),
+		file:      file,
+		startedAt: now,
+		expiredAt: now.Add(duration),
+	}
+	mid := opt.MinionID
+	sess.timer = time.AfterFunc(duration, func() {
+		if cp.remove(mid, sess) {
+			cp.log.Info(fmt.Sprintf("节点 %d 的抓包已到期自动停止，共记录 %d 个请求", mid, sess.entries.Load()))
+		}
+	})
+
+	cp.mutex.Lock()
+	old := cp.sessions[mid]
+	cp.sessions[mid] = sess
+	cp.mutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+
+	return sess.stat(), nil
+}
+
+func (cp *capturer) stop(mid int64) (*CaptureStat, error) {
+	sess := cp.get(mid)
+	if sess == nil || !cp.remove(mid, sess) {
+		return nil, ErrCaptureNotFound
+	}
+
+	return sess.stat(), nil
+}
+
+// remove 移除并关闭抓包，防止到期的定时器误删了重新开启的抓包。
+func (cp *capturer) remove(mid int64, sess *captureSession) bool {
+	cp.mutex.Lock()
+	if cp.sessions[mid] != sess {
+		cp.mutex.Unlock()
+		return false
+	}
+	delete(cp.sessions, mid)
+	cp.mutex.Unlock()
+	sess.close()
+
+	return true
+}
+
+func (cs *captureSession) close() {
+	cs.timer.Stop()
+	cs.mutex.Lock()
+	_ = cs.file.Close()
+	cs.mutex.Unlock()
+}
+
+func (cp *capturer) stats() []*CaptureStat {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+
+	ret := make([]*CaptureStat, 0, len(cp.sessions))
+	for _, sess := range cp.sessions {
+		ret = append(ret, sess.stat())
+	}
+
+	return ret
+}
+
+// wrapHandler 记录节点发起的请求（inbound）。
+func (cp *capturer) wrapHandler(conn *connect, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := cp.get(conn.id)
+		if sess == nil || r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ent := &CaptureEntry{
+			MinionID:  conn.id,
+			Inet:      conn.Inet().String(),
+			Direction: CaptureInbound,
+			StartedAt: time.Now(),
+			Request:   CaptureMessage{Method: r.Method, URL: r.URL.RequestURI(), Header: r.Header.Clone()},
+		}
+		var body []byte
+		var truncated bool
+		body, r.Body, truncated = peekBody(r.Body, sess.opt.MaxBody)
+		ent.Request.setBody(body, truncated)
+
+		cw := &captureWriter{ResponseWriter: w, code: http.StatusOK, limit: sess.opt.MaxBody}
+		h.ServeHTTP(cw, r)
+
+		res := &CaptureMessage{Status: cw.code, Header: w.Header().Clone()}
+		res.setBody(cw.buf.Bytes(), cw.truncated)
+		ent.Response = res
+		ent.Duration = time.Since(ent.StartedAt)
+		sess.write(ent)
+	})
+}
+
+// wrapTransport 记录 broker 发往节点的请求（outbound）。
+func (cp *capturer) wrapTransport(next http.RoundTripper) http.RoundTripper {
+	return &captureTransport{cp: cp, next: next}
+}
+
+type captureTransport struct {
+	cp   *capturer
+	next http.RoundTripper
+}
+
+func (ct *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	mid, _ := strconv.ParseInt(req.URL.Hostname(), 10, 64)
+	sess := ct.cp.get(mid)
+	if sess == nil || req.Header.Get("Upgrade") != "" {
+		return ct.next.RoundTrip(req)
+	}
+
+	ent := &CaptureEntry{
+		MinionID:  mid,
+		Direction: CaptureOutbound,
+		StartedAt: time.Now(),
+		Request:   CaptureMessage{Method: req.Method, URL: req.URL.RequestURI(), Header: req.Header.Clone()},
+	}
+	if conn := ct.cp.hub.section.Get(req.URL.Hostname()); conn != nil {
+		ent.Inet = conn.Inet().String()
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		var body []byte
+		var truncated bool
+		body, req.Body, truncated = peekBody(req.Body, sess.opt.MaxBody)
+		ent.Request.setBody(body, truncated)
+	}
+
+	res, err := ct.next.RoundTrip(req)
+	if err != nil {
+		ent.Duration = time.Since(ent.StartedAt)
+		ent.Error = err.Error()
+		sess.write(ent)
+		return nil, err
+	}
+	res.Body = &captureBody{ReadCloser: res.Body, limit: sess.opt.MaxBody, done: func(raw []byte, truncated bool) {
+		msg := &CaptureMessage{Status: res.StatusCode, Header: res.Header.Clone()}
+		msg.setBody(raw, truncated)
+		ent.Response = msg
+		ent.Duration = time.Since(ent.StartedAt)
+		sess.write(ent)
+	}}
+
+	return res, nil
+}
+
+// peekBody 读取 Body 的前 limit 个字节用于记录，返回的 ReadCloser 仍然可以完整的读取原 Body。
+func peekBody(rc io.ReadCloser, limit int) ([]byte, io.ReadCloser, bool) {
+	if rc == nil || rc == http.NoBody {
+		return nil, rc, false
+	}
+	buf, _ := io.ReadAll(io.LimitReader(rc, int64(limit)+1))
+	body := struct {
+		io.Reader
+		io.Closer
+	}{Reader: io.MultiReader(bytes.NewReader(buf), rc), Closer: rc}
+	if len(buf) > limit {
+		return buf[:limit], body, true
+	}
+
+	return buf, body, false
+}
+
+// captureWriter 记录响应状态码和 Body 的前 limit 个字节。
+type captureWriter struct {
+	http.ResponseWriter
+	code      int
+	wrote     bool
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if !cw.wrote {
+		cw.code, cw.wrote = code, true
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	cw.wrote = true
+	if rest := cw.limit - cw.buf.Len(); rest < len(b) {
+		cw.buf.Write(b[:max(rest, 0)])
+		cw.truncated = true
+	} else {
+		cw.buf.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush 流式输出的接口需要 http.Flusher，不能被包装隐藏。
+func (cw *captureWriter) Flush() {
+	cw.wrote = true
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+// captureBody 记录响应 Body 的前 limit 个字节，读取完毕或关闭时回调 done。
+type captureBody struct {
+	io.ReadCloser
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+	once      sync.Once
+	done      func(raw []byte, truncated bool)
+}
+
+func (cb *captureBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	if rest := cb.limit - cb.buf.Len(); rest < n {
+		cb.buf.Write(p[:max(rest, 0)])
+		cb.truncated = true
+	} else {
+		cb.buf.Write(p[:n])
+	}
+	if err == io.EOF {
+		cb.finish()
+	}
+	return n, err
+}
+
+func (cb *captureBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.finish()
+	return err
+}
+
+func (cb *captureBody) finish() {
+	cb.once.Do(func() { cb.done(cb.buf.Bytes(), cb.truncated) })
+}
+
+// redactMask 脱敏后的值。
+const redactMask = "******"
+
+// redactor 脱敏规则：名字匹配（不区分大小写）的 Header、Query 参数和 JSON/表单字段的值会被替换。
+// 其它文本 Body（包括被截断、无法解析、没有 Content-Type 的）按照正则匹配 "key": "value" 和 key=value 的形式替换。
+type redactor struct {
+	keys    map[string]struct{}
+	pattern *regexp.Regexp
+}
+
+var defaultRedactKeys = []string{
+	"authorization", "proxy-authorization", "cookie", "set-cookie", "x-auth-token", "x-token",
+	"password", "passwd", "pwd", "secret", "token", "access_token", "refresh_token",
+	"api_key", "apikey", "secret_key", "private_key", "credential",
+}
+
+func newRedactor(extra []string) redactor {
+	keys := make(map[string]struct{}, len(defaultRedactKeys)+len(extra))
+	quotes := make([]string, 0, len(defaultRedactKeys)+len(extra))
+	for _, key := range slices.Concat(defaultRedactKeys, extra) {
+		key = strings.ToLower(key)
+		if _, ok := keys[key]; !ok {
+			keys[key] = struct{}{}
+			quotes = append(quotes, regexp.QuoteMeta(key))
+		}
+	}
+	names := strings.Join(quotes, "|")
+	pattern := regexp.MustCompile(`(?i)("(?:` + names + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)` +
+		`|(\b(?:` + names + `)=)([^&\s]*)`)
+
+	return redactor{keys: keys, pattern: pattern}
+}
+
+func (rd redactor) match(key string) bool {
+	_, ok := rd.keys[strings.ToLower(key)]
+	return ok
+}
+
+// text 按照正则脱敏无法解析的文本。
+func (rd redactor) text(s string) string {
+	return rd.pattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := rd.pattern.FindStringSubmatch(m)
+		if sub[1] != "" {
+			return sub[1] + `"` + redactMask + `"`
+		}
+		return sub[3] + redactMask
+	})
+}
+
+func (rd redactor) url(uri string) string {
+	path, query, found := strings.Cut(uri, "?")
+	if !found {
+		return uri
+	}
+	vals, err := url.ParseQuery(query)
+	if err != nil {
+		return uri
+	}
+	if !rd.values(vals) {
+		return uri
+	}
+	return path + "?" + vals.Encode()
+}
+
+func (rd redactor) values(vals url.Values) bool {
+	var changed bool
+	for key, vs := range vals {
+		if rd.match(key) {
+			for i := range vs {
+				vs[i] = redactMask
+			}
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (rd redactor) message(m *CaptureMessage) {
+	for key, vs := range m.Header {
+		if rd.match(key) {
+			for i := range vs {
+				vs[i] = redactMask
+			}
+		}
+	}
+	if m.Body == "" {
+		return
+	}
+	if !m.Truncated {
+		ctype := m.Header.Get("Content-Type")
+		switch {
+		case strings.Contains(ctype, "json"):
+			if body, ok := rd.jsonBody(m.Body); ok {
+				m.Body = body
+				return
+			}
+		case strings.HasPrefix(ctype, "application/x-www-form-urlencoded"):
+			if vals, err := url.ParseQuery(m.Body); err == nil {
+				if rd.values(vals) {
+					m.Body = vals.Encode()
+				}
+				return
+			}
+		}
+	}
+	m.Body = rd.text(m.Body)
+}
+
+// jsonBody 解析 JSON 并脱敏，数字按照原样保留（大于 2^53 的整数 ID 不会丢失精度）。
+func (rd redactor) jsonBody(body string) (string, bool) {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return "", false
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(rd.json(v)); err != nil {
+		return "", false
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
+
+func (rd redactor) json(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for key, elem := range val {
+			if rd.match(key) {
+				val[key] = redactMask
+			} else {
+				val[key] = rd.json(elem)
+			}
+		}
+	case []any:
+		for i, elem := range val {
+			val[i] = rd.json(elem)
+		}
+	}
+	return v
+}
+
+// rotateFile 按照大小滚动的文件：当前文件超过 maxSize 后依次重命名为 .1 .2 ...，
+// 超过 maxFiles 的旧文件会被删除。
+type rotateFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotateFile(path string, maxSize int64, maxFiles int) (*rotateFile, error) {
+	rf := &rotateFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *rotateFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	rf.file, rf.size = file, info.Size()
+
+	return nil
+}
+
+func (rf *rotateFile) Write(p []byte) (int, error) {
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+func (rf *rotateFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+
+	_ = os.Remove(rf.path + "." + strconv.Itoa(rf.maxFiles-1))
+	for i := rf.maxFiles - 2; i > 0; i-- {
+		_ = os.Rename(rf.path+"."+strconv.Itoa(i), rf.path+"."+strconv.Itoa(i+1))
+	}
+	if rf.maxFiles > 1 {
+		_ = os.Rename(rf.path, rf.path+".1")
+	} else {
+		_ = os.Remove(rf.path)
+	}
+
+	return rf.open()
+}
+
+func (rf *rotateFile) Close() error {
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// Capture 开启节点流量抓包，同一节点重复开启时会替换之前的抓包。
+func (hub *minionHub) Capture(opt CaptureOption) (*CaptureStat, error) {
+	return hub.capture.start(opt)
+}
+
+// Uncapture 停止节点流量抓包。
+func (hub *minionHub) Uncapture(mid int64) (*CaptureStat, error) {
+	return hub.capture.stop(mid)
+}
+
+// Captures 进行中的抓包。
+func (hub *minionHub) Captures() []*CaptureStat {
+	return hub.capture.stats()
+}
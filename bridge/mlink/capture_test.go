@@ -0,0 +1,213 @@
+package mlink
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactorMessage(t *testing.T) {
+	rd := newRedactor([]string{"X-Custom"})
+
+	tests := []struct {
+		name   string
+		ctype  string
+		body   string
+		trunc  bool
+		want   string
+		hidden string // 脱敏后不应该出现的内容
+	}{
+		{
+			name:   "JSON 字段",
+			ctype:  "application/json",
+			body:   `{"user":"root","password":"p@ss","nested":{"Token":"abc"}}`,
+			want:   `{"nested":{"Token":"******"},"password":"******","user":"root"}`,
+			hidden: "p@ss",
+		},
+		{
+			name:  "JSON 大整数保持精度",
+			ctype: "application/json; charset=utf-8",
+			body:  `{"id":1234567890123456789,"ratio":0.1,"secret":1}`,
+			want:  `{"id":1234567890123456789,"ratio":0.1,"secret":"******"}`,
+		},
+		{
+			name:  "JSON 不转义 HTML 字符",
+			ctype: "application/json",
+			body:  `{"cmd":"a && b < c"}`,
+			want:  `{"cmd":"a && b < c"}`,
+		},
+		{
+			name:   "自定义字段",
+			ctype:  "application/json",
+			body:   `{"x-custom":"v"}`,
+			want:   `{"x-custom":"******"}`,
+			hidden: `"v"`,
+		},
+		{
+			name:   "表单",
+			ctype:  "application/x-www-form-urlencoded",
+			body:   "user=root&pwd=123",
+			want:   "pwd=%2A%2A%2A%2A%2A%2A&user=root",
+			hidden: "123",
+		},
+		{
+			name:  "表单没有敏感字段时保持原样",
+			ctype: "application/x-www-form-urlencoded",
+			body:  "b=2&a=1",
+			want:  "b=2&a=1",
+		},
+		{
+			name:   "没有 Content-Type",
+			body:   `{"token": "abc", "n": 1}`,
+			want:   `{"token": "******", "n": 1}`,
+			hidden: "abc",
+		},
+		{
+			name:   "纯文本",
+			ctype:  "text/plain",
+			body:   "login password=abc&user=root",
+			want:   "login password=******&user=root",
+			hidden: "abc",
+		},
+		{
+			name:   "JSON 解析失败时按照文本脱敏",
+			ctype:  "application/json",
+			body:   `{"secret": "abc"} trailing`,
+			want:   `{"secret": "******"} trailing`,
+			hidden: "abc",
+		},
+		{
+			name:   "被截断的 JSON",
+			ctype:  "application/json",
+			body:   `{"user":"root","access_token":"abcdef`,
+			trunc:  true,
+			want:   `{"user":"root","access_token":"******"`,
+			hidden: "abcdef",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &CaptureMessage{Header: http.Header{}, Body: tt.body, Truncated: tt.trunc}
+			if tt.ctype != "" {
+				msg.Header.Set("Content-Type", tt.ctype)
+			}
+			rd.message(msg)
+			if msg.Body != tt.want {
+				t.Errorf("body = %s, want %s", msg.Body, tt.want)
+			}
+			if tt.hidden != "" && strings.Contains(msg.Body, tt.hidden) {
+				t.Errorf("body = %s, 仍然包含 %s", msg.Body, tt.hidden)
+			}
+		})
+	}
+}
+
+func TestRedactorHeaderAndURL(t *testing.T) {
+	rd := newRedactor(nil)
+	msg := &CaptureMessage{Header: http.Header{
+		"Authorization": {"Bearer abc"},
+		"Cookie":        {"a=1", "b=2"},
+		"Accept":        {"*/*"},
+	}}
+	rd.message(msg)
+	for key, want := range map[string]string{"Authorization": redactMask, "Cookie": redactMask, "Accept": "*/*"} {
+		if got := msg.Header.Get(key); got != want {
+			t.Errorf("header %s = %s, want %s", key, got, want)
+		}
+	}
+
+	urls := map[string]string{
+		"/api/v1/ping":             "/api/v1/ping",
+		"/api/v1/ping?a=1":         "/api/v1/ping?a=1",
+		"/api/v1/ping?token=abc":   "/api/v1/ping?token=%2A%2A%2A%2A%2A%2A",
+		"/api/v1/ping?API_KEY=abc": "/api/v1/ping?API_KEY=%2A%2A%2A%2A%2A%2A",
+	}
+	for in, want := range urls {
+		if got := rd.url(in); got != want {
+			t.Errorf("url(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestCaptureMessageSetBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		raw      []byte
+		trunc    bool
+		body     string
+		omitted  bool
+	}{
+		{name: "文本", raw: []byte("hello"), body: "hello"},
+		{name: "压缩", encoding: "gzip", raw: []byte("hello"), omitted: true},
+		{name: "identity 编码", encoding: "identity", raw: []byte("hello"), body: "hello"},
+		{name: "二进制", raw: []byte{0xff, 0xfe, 0x00}, omitted: true},
+		{name: "截断在多字节字符中间", raw: []byte("中文")[:4], trunc: true, body: "中"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &CaptureMessage{Header: http.Header{}}
+			if tt.encoding != "" {
+				msg.Header.Set("Content-Encoding", tt.encoding)
+			}
+			msg.setBody(tt.raw, tt.trunc)
+			if msg.Body != tt.body || msg.Omitted != tt.omitted {
+				t.Errorf("body = %q omitted = %v, want %q %v", msg.Body, msg.Omitted, tt.body, tt.omitted)
+			}
+		})
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxFiles int
+		writes   int
+		want     []string // 写入后存在的文件及其内容
+	}{
+		{name: "未超过大小", maxFiles: 3, writes: 1, want: []string{"0"}},
+		{name: "滚动一次", maxFiles: 3, writes: 2, want: []string{"1", "0"}},
+		{name: "保留最近的文件", maxFiles: 3, writes: 5, want: []string{"4", "3", "2"}},
+		{name: "只保留当前文件", maxFiles: 1, writes: 3, want: []string{"2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "capture", "minion.ndjson")
+			rf, err := openRotateFile(path, 8, tt.maxFiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range tt.writes {
+				// 每次写入 6 个字节，第二次写入就会超过 8 字节的上限
+				if _, err = rf.Write([]byte(strings.Repeat(string(rune('0'+i)), 6))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = rf.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i <= tt.maxFiles; i++ {
+				name := path
+				if i > 0 {
+					name += "." + string(rune('0'+i))
+				}
+				raw, err := os.ReadFile(name)
+				if i >= len(tt.want) {
+					if err == nil {
+						t.Errorf("%s 不应该存在", filepath.Base(name))
+					}
+					continue
+				}
+				if want := strings.Repeat(tt.want[i], 6); string(raw) != want {
+					t.Errorf("%s = %q, want %q", filepath.Base(name), raw, want)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package mlink
 import (
 	"context"
 	"net"
+	"net/netip"
+	"sync/atomic"
 
 	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
 	"github.com/vela-ssoc/vela-common-mba/smux"
@@ -19,6 +21,9 @@ type connect struct {
 	ident gateway.Ident
 	issue gateway.Issue
 	mux   *smux.Session
+	peer  netip.Addr   // 来源地址（TCP 对端地址）
+	token atomic.Int64 // 会话租约的防护令牌
+	telemetry
 	// mux   spdy.Muxer
 }
 
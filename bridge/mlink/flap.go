@@ -0,0 +1,165 @@
+package mlink
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+)
+
+// flapConfigKey 闪断抑制的配置项，value 为 FlapConfig 的 JSON。
+const flapConfigKey = "minion.flap"
+
+// FlapConfig 节点闪断抑制配置，由中心端写入 broker 运行时配置。
+type FlapConfig struct {
+	// Grace 宽限期（秒）：节点断开后在宽限期内重连，则不产生下线、上线事件，
+	// 也不再重复下发 startup 和配置。默认为 0，小于等于 0 表示关闭闪断抑制。
+	Grace int `json:"grace"`
+
+	// Window 统计闪断次数的时间窗口（秒）。
+	Window int `json:"window"`
+
+	// Threshold 窗口内闪断次数达到该值时，产生一次节点抖动事件。
+	Threshold int `json:"threshold"`
+}
+
+func (fc FlapConfig) grace() time.Duration  { return time.Duration(fc.Grace) * time.Second }
+func (fc FlapConfig) window() time.Duration { return time.Duration(fc.Window) * time.Second }
+
+// flapper 节点闪断抑制：节点断开后延迟宽限期再产生下线事件，
+// 宽限期内重连则下线、上线事件一并取消，并记为一次闪断。
+//
+// 节点在宽限期内重连到了其它 broker 时无法抑制，两边各自产生事件。
+type flapper struct {
+	mutex sync.Mutex
+	nodes map[int64]*flapState
+
+	cfgMutex sync.Mutex
+	config   FlapConfig
+	readAt   time.Time // 配置的读取时间
+}
+
+type flapState struct {
+	timer     *time.Timer   // 延迟的下线事件，到期或被取消后置为 nil，回调据此判断自己是否已被取消
+	emitting  chan struct{} // 正在产生的下线事件，产生完毕后关闭
+	flaps     []time.Time   // 窗口内的闪断时间
+	alertedAt time.Time     // 最近一次产生抖动事件的时间
+}
+
+// flapConfig 读取闪断抑制配置，结果缓存一分钟。
+func (hub *minionHub) flapConfig(ctx context.Context) FlapConfig {
+	fl := &hub.flap
+	fl.cfgMutex.Lock()
+	defer fl.cfgMutex.Unlock()
+
+	now := time.Now()
+	if now.Before(fl.readAt.Add(time.Minute)) {
+		return fl.config
+	}
+
+	cfg := FlapConfig{Window: 600, Threshold: 5}
+	_ = hub.runtimeConfig(ctx, flapConfigKey, &cfg)
+	if cfg.Window < cfg.Grace {
+		cfg.Window = cfg.Grace
+	}
+	fl.config, fl.readAt = cfg, now
+
+	return cfg
+}
+
+// connected 节点上线。宽限期内重连时取消延迟的下线事件并返回 true，
+// 此时调用方不应再产生上线事件。延迟的下线事件正在产生时，等待其完成后再返回 false，
+// 保证下线事件在上线事件之前。
+func (hub *minionHub) connected(ident gateway.Ident, issue gateway.Issue, at time.Time) bool {
+	cfg := hub.flapConfig(context.Background())
+	fl := &hub.flap
+	id := issue.ID
+
+	fl.mutex.Lock()
+	st := fl.nodes[id]
+	if st == nil || st.timer == nil {
+		var emitting chan struct{}
+		if st != nil {
+			emitting = st.emitting
+		}
+		fl.mutex.Unlock()
+		if emitting != nil {
+			<-emitting
+		}
+		return false
+	}
+	defer fl.mutex.Unlock()
+
+	// Stop 返回 false 说明回调已经触发但还在等锁，置为 nil 后回调会放弃产生下线事件。
+	st.timer.Stop()
+	st.timer = nil
+
+	// 只保留窗口内的闪断记录
+	since := at.Add(-cfg.window())
+	flaps := st.flaps[:0]
+	for _, t := range st.flaps {
+		if t.After(since) {
+			flaps = append(flaps, t)
+		}
+	}
+	st.flaps = append(flaps, at)
+
+	count := len(st.flaps)
+	if cfg.Threshold > 0 && count >= cfg.Threshold && st.alertedAt.Before(since) {
+		st.alertedAt = at
+		hub.phase.Flapping(id, ident, count, cfg.window(), at)
+	}
+
+	return true
+}
+
+// disconnected 节点下线，开启闪断抑制时延迟宽限期再产生下线事件。
+func (hub *minionHub) disconnected(ident gateway.Ident, issue gateway.Issue, at time.Time, du time.Duration) {
+	cfg := hub.flapConfig(context.Background())
+	grace := cfg.grace()
+	if grace <= 0 {
+		hub.phase.Disconnected(ident, issue, at, du)
+		return
+	}
+
+	fl := &hub.flap
+	id := issue.ID
+
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+
+	st := fl.nodes[id]
+	if st == nil {
+		st = new(flapState)
+		fl.nodes[id] = st
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(grace, func() {
+		fl.mutex.Lock()
+		if st.timer != timer { // 宽限期内已经重连
+			fl.mutex.Unlock()
+			return
+		}
+		st.timer = nil
+		emitting := make(chan struct{})
+		st.emitting = emitting
+		fl.mutex.Unlock()
+
+		hub.phase.Disconnected(ident, issue, at, du)
+
+		fl.mutex.Lock()
+		close(emitting)
+		if st.emitting == emitting {
+			st.emitting = nil
+		}
+		// 窗口内没有闪断记录的节点不必再保留状态
+		if last := len(st.flaps); st.timer == nil && st.emitting == nil &&
+			(last == 0 || time.Since(st.flaps[last-1]) > cfg.window()) && fl.nodes[id] == st {
+			delete(fl.nodes, id)
+		}
+		fl.mutex.Unlock()
+	})
+	st.timer = timer
+}
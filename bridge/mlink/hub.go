@@ -10,9 +10,12 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -23,8 +26,6 @@ import (
 	"github.com/vela-ssoc/ssoc-common-mb/problem"
 	"github.com/vela-ssoc/vela-common-mba/netutil"
 	"github.com/vela-ssoc/vela-common-mba/smux"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 var (
@@ -53,11 +54,50 @@ type Huber interface {
 
 	Unicast(ctx context.Context, id int64, path string, body, resp any) error
 
-	// Knockout 根据 minionID 断开节点连接
-	Knockout(mid int64)
+	// Multicast 并发向多个节点发送消息，通过 channel 逐个返回每个节点的执行结果。
+	Multicast(ctx context.Context, ids []int64, path string, body any) <-chan *MulticastResult
+
+	// Knockout 根据 minionID 断开节点连接，ban 大于 0 时同时封禁该节点一段时间。
+	Knockout(ctx context.Context, mid int64, reason string, ban time.Duration) error
+
+	// Ban 封禁节点 ID 或 IP 并断开匹配的在线连接，du 小于等于 0 代表永久封禁。
+	Ban(ctx context.Context, b *Ban, du time.Duration) error
+
+	// Unban 解除节点 ID 或 IP 的封禁。
+	Unban(ctx context.Context, mid int64, inet string) error
+
+	// Bans 所有生效中的封禁记录。
+	Bans(ctx context.Context) ([]*Ban, error)
+
+	// Select 根据条件筛选在线节点的 ID，使用内存中的索引，不需要查询数据库。
+	Select(f Filter) []int64
+
+	// Retag 从数据库重新加载节点的标签，节点标签变更后调用。
+	Retag(ctx context.Context, mid int64) error
+
+	// Stats 所有在线节点连接的统计信息。
+	Stats() []ConnStat
+
+	// Drain 进入排空状态，不再接纳新的节点，已连接的节点不受影响。
+	Drain()
+
+	// Draining 是否处于排空状态。
+	Draining() bool
+
+	// Admission 当前生效的节点准入策略，reload 为 true 时立即从数据库重新加载。
+	Admission(ctx context.Context, reload bool) *AdmissionPolicy
+
+	// Capture 开启节点流量抓包，记录节点与 broker 之间的 HTTP 请求，同一节点重复开启时替换之前的抓包。
+	Capture(opt CaptureOption) (*CaptureStat, error)
+
+	// Uncapture 停止节点流量抓包。
+	Uncapture(mid int64) (*CaptureStat, error)
+
+	// Captures 进行中的抓包。
+	Captures() []*CaptureStat
 }
 
-func LinkHub(qry *query.Query, link telecom.Linker, handler http.Handler, phase NodePhaser, log *slog.Logger) Linker {
+func LinkHub(parent context.Context, qry *query.Query, link telecom.Linker, handler http.Handler, phase *PhaseBus, log *slog.Logger) Linker {
 	seed := time.Now().UnixNano()
 	random := rand.New(rand.NewSource(seed))
 
@@ -68,15 +108,20 @@ func LinkHub(qry *query.Query, link telecom.Linker, handler http.Handler, phase
 		bid:     link.Ident().ID,
 		name:    link.Name(),
 		log:     log,
-		section: newSegmentMap(128, 64), // 预分配 8192 个连接空间，已经足够使用了。
+		section: newRegistry(newSegmentMap(128, 64)), // 预分配 8192 个连接空间，已经足够使用了。
 		phase:   phase,
 		random:  random,
 	}
+	hub.flap.nodes = make(map[int64]*flapState, 64)
+	hub.capture = capturer{hub: hub, log: log, sessions: make(map[int64]*captureSession, 8)}
 
-	trip := &http.Transport{DialContext: hub.dialContext}
+	trip := hub.capture.wrapTransport(hub.telemetryTransport(&http.Transport{DialContext: hub.dialContext}))
 	hub.client = netutil.NewClient(trip)
 	hub.stream = netutil.NewStream(hub.dialContext)
-	hub.proxy = netutil.NewForward(trip, hub.forwardError)
+	hub.proxy = netutil.NewForward(newArrTransport(hub, trip), hub.forwardError)
+	go hub.renewLeases(parent)
+	go hub.reconcile(parent)
+	go hub.sweepBans(parent)
 
 	return hub
 }
@@ -89,11 +134,20 @@ type minionHub struct {
 	client  netutil.HTTPClient
 	proxy   netutil.Forwarder
 	stream  netutil.Streamer
-	phase   NodePhaser
-	section container
+	phase   *PhaseBus
+	section *registry
 	bid     int64  // 当前 broker ID
 	name    string // 当前 broker 名字
 	random  *rand.Rand
+	drain   atomic.Bool // 是否处于排空状态
+
+	policyMu sync.Mutex
+	policy   string    // 身份冲突处理策略
+	policyAt time.Time // 策略的读取时间
+
+	flap      flapper   // 闪断抑制
+	admission admission // 准入策略
+	capture   capturer  // 流量抓包
 }
 
 func (hub *minionHub) Link() telecom.Linker {
@@ -102,64 +156,42 @@ func (hub *minionHub) Link() telecom.Linker {
 
 func (hub *minionHub) Auth(ctx context.Context, ident gateway.Ident) (gateway.Issue, http.Header, bool, error) {
 	var issue gateway.Issue
-	ip := ident.Inet.To4()
-	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+	inet, inet6 := ident.Inets()
+	if inet == "" {
 		return issue, nil, false, ErrMinionBadInet
 	}
-
-	// 根据 inet 查询节点信息
+	// 封禁检查要在 resolve 之前：resolve 可能新建节点、改写节点 IP 和身份绑定，
+	// 被封禁的节点不能对节点记录产生任何影响。IP 封禁同时匹配节点声明的地址和来源地址。
 	now := time.Now()
-	inet := ip.String()
-	monTbl := hub.qry.Minion
-	mon, err := monTbl.WithContext(ctx).Where(monTbl.Inet.Eq(inet)).First()
+	ids, err := hub.banCandidates(ctx, ident, inet)
 	if err != nil {
-		if err != gorm.ErrRecordNotFound {
-			return issue, nil, false, err
-		}
-
-		join := &model.Minion{
-			Inet: inet,
-			// Name:       inet,
-			MAC:    ident.MAC,
-			Goos:   ident.Goos,
-			Arch:   ident.Arch,
-			Status: model.MSOffline,
-			// Semver:     ident.Semver,
-			// CPU:        ident.CPU,
-			// PID:        ident.PID,
-			// Username:   ident.Username,
-			// Hostname:   ident.Hostname,
-			// Workdir:    ident.Workdir,
-			// Executable: ident.Executable,
-			// JoinedAt:   now,
-			Unstable:   ident.Unstable,
-			Customized: ident.Customized,
-			Unload:     ident.Unload,
-			BrokerID:   hub.link.Ident().ID,
-			BrokerName: hub.link.Issue().Name,
-			CreatedAt:  now,
-			UpdatedAt:  now,
-		}
+		gateway.Observe(ctx, time.Since(now), err)
+		return issue, nil, false, err
+	}
+	inets := []string{inet, inet6}
+	if peer := gateway.Peer(ctx); peer.IsValid() {
+		inets = append(inets, peer.String())
+	}
+	if ban, exx := hub.banned(ctx, ids, inets...); exx != nil {
+		return issue, nil, false, exx
+	} else if ban != nil {
+		berr := &BannedError{Ban: ban}
+		return issue, berr.Header(), true, berr
+	}
 
-		if err = hub.qry.Transaction(func(tx *query.Query) error {
-			if exx := tx.WithContext(ctx).Minion.Create(join); exx != nil {
-				return exx
-			}
-			mid, goos, arch := join.ID, ident.Goos, ident.Arch
-			tags := model.MinionTags{
-				{Tag: goos, MinionID: mid, Kind: model.TkLifelong},
-				{Tag: arch, MinionID: mid, Kind: model.TkLifelong},
-				{Tag: inet, MinionID: mid, Kind: model.TkLifelong},
-			}
-			return tx.WithContext(ctx).MinionTag.
-				Clauses(clause.OnConflict{DoNothing: true}).
-				Create(tags...)
-		}); err != nil {
-			return issue, nil, false, err
-		}
+	// 根据 host id、inet、MAC 等信息确定节点身份
+	mon, err := hub.resolve(ctx, ident, inet, now)
+	gateway.Observe(ctx, time.Since(now), infraError(err))
+	if err != nil {
+		return issue, nil, false, err
+	}
 
-		mon = join
-		hub.phase.Created(join.ID, inet, now)
+	// 身份冲突时 resolve 可能选择了候选之外的节点，再按照最终的节点 ID 检查一次。
+	if ban, exx := hub.banned(ctx, []int64{mon.ID}); exx != nil {
+		return issue, nil, false, exx
+	} else if ban != nil {
+		berr := &BannedError{Ban: ban}
+		return issue, berr.Header(), true, berr
 	}
 
 	status := mon.Status
@@ -170,7 +202,14 @@ func (hub *minionHub) Auth(ctx context.Context, ident gateway.Ident) (gateway.Is
 		return issue, nil, true, ErrMinionRemove
 	}
 	if status == model.MSOnline {
-		return issue, nil, false, ErrMinionOnline
+		// 状态为在线但租约已经失效，说明原来的 broker 已经宕机，允许接管。
+		held, exx := hub.leaseHeld(ctx, mon.ID)
+		if exx != nil {
+			return issue, nil, false, exx
+		}
+		if held {
+			return issue, nil, false, ErrMinionOnline
+		}
 	}
 
 	issue.ID = mon.ID
@@ -194,7 +233,7 @@ func (hub *minionHub) Join(parent context.Context, tran net.Conn, ident gateway.
 	defer mux.Close()
 
 	id := issue.ID
-	inet := ident.Inet.String()
+	inet, inet6 := ident.Inets()
 	now := time.Now()
 	sid := strconv.FormatInt(id, 10) // 方便 dialContext
 	conn := &connect{
@@ -203,6 +242,10 @@ func (hub *minionHub) Join(parent context.Context, tran net.Conn, ident gateway.
 		issue: issue,
 		mux:   mux,
 	}
+	if addr, exx := netip.ParseAddrPort(tran.RemoteAddr().String()); exx == nil {
+		conn.peer = addr.Addr().Unmap()
+	}
+	conn.joinedAt = now
 
 	if !hub.section.Put(sid, conn) {
 		hub.phase.Repeated(id, ident, now)
@@ -210,6 +253,21 @@ func (hub *minionHub) Join(parent context.Context, tran net.Conn, ident gateway.
 	}
 	defer hub.section.Del(sid)
 
+	actx, acancel := context.WithTimeout(parent, 10*time.Second)
+	token, err := hub.acquireLease(actx, id, inet, now)
+	acancel()
+	gateway.Observe(parent, time.Since(now), infraError(err))
+	if err != nil {
+		hub.log.Warn(fmt.Sprintf("节点 %s(%d) 获取会话租约失败：%v", inet, id, err))
+		return err
+	}
+	conn.token.Store(token)
+	defer func() {
+		rctx, rcancel := context.WithTimeout(context.Background(), 10*time.Second)
+		hub.releaseLease(rctx, id, token)
+		rcancel()
+	}()
+
 	nullableAt := sql.NullTime{Valid: true, Time: now}
 
 	// 存在这样的情况，例如节点 192.168.18.18 变更系统后，与之对应的永久标签也要切换。
@@ -218,10 +276,12 @@ func (hub *minionHub) Join(parent context.Context, tran net.Conn, ident gateway.
 	online, offline := uint8(model.MSOnline), uint8(model.MSOffline)
 	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
 	monTbl := hub.qry.Minion
+	updateAt := time.Now()
 	info, err := monTbl.WithContext(ctx).
-		Where(monTbl.ID.Eq(id), monTbl.Status.Eq(uint8(model.MSOffline))).
+		Where(monTbl.ID.Eq(id), monTbl.Status.In(offline, online)).
 		UpdateSimple(
 			monTbl.Status.Value(online),
+			monTbl.Inet6.Value(inet6),
 			monTbl.MAC.Value(ident.MAC),
 			monTbl.Goos.Value(ident.Goos),
 			monTbl.Arch.Value(ident.Arch),
@@ -233,11 +293,18 @@ func (hub *minionHub) Join(parent context.Context, tran net.Conn, ident gateway.
 			monTbl.BrokerName.Value(brokerName),
 		)
 	cancel()
+	gateway.Observe(parent, time.Since(updateAt), err)
 	if err != nil || info.RowsAffected == 0 {
 		hub.log.Warn(fmt.Sprintf("节点 %s(%d) 修改上线状态失败：%v", inet, id, err))
 		return err
 	}
 
+	tctx, tcancel := context.WithTimeout(parent, 10*time.Second)
+	if exx := hub.Retag(tctx, id); exx != nil {
+		hub.log.Warn(fmt.Sprintf("节点 %s(%d) 加载标签失败：%v", inet, id, exx))
+	}
+	tcancel()
+
 	defer func() {
 		dctx, dcancel := context.WithTimeout(context.Background(), 3*time.Minute)
 		ret, exx := monTbl.WithContext(dctx).
@@ -254,21 +321,34 @@ func (hub *minionHub) Join(parent context.Context, tran net.Conn, ident gateway.
 	}()
 
 	srv := &http.Server{
-		Handler: hub.handler,
+		Handler: conn.wrapHandler(hub.capture.wrapHandler(conn, hub.handler)),
 		BaseContext: func(net.Listener) context.Context {
 			return context.WithValue(context.Background(), minionCtxKey, conn)
 		},
 	}
 
-	hub.phase.Connected(hub, ident, issue, now)
-	_ = srv.Serve(mux)
+	if !hub.connected(ident, issue, now) {
+		hub.phase.Connected(ident, issue, now)
+	}
+	_ = srv.Serve(&statListener{Listener: mux, tm: &conn.telemetry})
 	after := time.Now()
 	du := after.Sub(now)
-	hub.phase.Disconnected(hub, ident, issue, after, du)
+	hub.disconnected(ident, issue, after, du)
 
 	return nil
 }
 
+// infraError 过滤掉节点重复登录、身份冲突等业务上的拒绝，只保留数据库等基础设施的错误，
+// 用于网关的自适应限流。
+func infraError(err error) error {
+	if err == nil ||
+		errors.Is(err, ErrMinionOnline) ||
+		errors.Is(err, ErrMinionConflict) {
+		return nil
+	}
+	return err
+}
+
 func (hub *minionHub) Name() string {
 	return hub.name
 }
@@ -281,10 +361,19 @@ func (hub *minionHub) ResetDB() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
+	var ids []int64
+	_ = tbl.WithContext(ctx).
+		Where(tbl.BrokerID.Eq(hub.bid), tbl.Status.Eq(online)).
+		Pluck(tbl.ID, &ids)
+
 	_, err := tbl.WithContext(ctx).
 		Where(tbl.BrokerID.Eq(hub.bid), tbl.Status.Eq(online)).
 		UpdateColumnSimple(tbl.Status.Value(offline))
-	return err
+	if err != nil {
+		return err
+	}
+
+	return hub.dropLeases(ctx, ids)
 }
 
 func (hub *minionHub) Forward(w http.ResponseWriter, r *http.Request) {
@@ -319,17 +408,26 @@ func (hub *minionHub) ConnectIDs() []int64 {
 	return hub.section.IDs()
 }
 
-func (hub *minionHub) Knockout(mid int64) {
-	if mid == 0 {
-		return
+func (hub *minionHub) Stats() []ConnStat {
+	conns := hub.section.Conns()
+	ret := make([]ConnStat, 0, len(conns))
+	for _, c := range conns {
+		ret = append(ret, c.stat())
 	}
 
-	id := strconv.FormatInt(mid, 10)
-	if conn := hub.section.Del(id); conn != nil {
-		_ = conn.mux.Close()
+	return ret
+}
+
+func (hub *minionHub) Drain() {
+	if hub.drain.CompareAndSwap(false, true) {
+		hub.log.Warn("broker 进入排空状态，不再接纳新的节点")
 	}
 }
 
+func (hub *minionHub) Draining() bool {
+	return hub.drain.Load()
+}
+
 func (hub *minionHub) sendJSON(ctx context.Context, id int64, path string, req any) (*http.Response, error) {
 	if ctx == nil {
 		var cancel context.CancelFunc
@@ -374,7 +472,7 @@ func (hub *minionHub) dialContext(_ context.Context, _, addr string) (net.Conn,
 	if stream, exx := conn.mux.OpenStream(); exx != nil {
 		return nil, exx
 	} else {
-		return stream, nil
+		return conn.wrapStream(stream), nil
 	}
 }
 
@@ -382,10 +480,24 @@ func (hub *minionHub) forwardError(w http.ResponseWriter, r *http.Request, err e
 	pd := &problem.Detail{
 		Type:     hub.name,
 		Title:    "网关错误",
-		Status:   http.StatusBadRequest,
+		Status:   http.StatusBadGateway,
 		Detail:   err.Error(),
 		Instance: r.RequestURI,
 	}
 
+	var be *BreakerError
+	switch {
+	case errors.Is(err, ErrMinionOffline):
+		pd.Title, pd.Status = "节点不在线", http.StatusServiceUnavailable
+	case errors.Is(err, ErrArrTimeout):
+		pd.Title, pd.Status = "节点响应超时", http.StatusGatewayTimeout
+	case errors.As(err, &be):
+		pd.Title, pd.Status = "节点熔断中", http.StatusServiceUnavailable
+		sec := int((be.After + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(sec))
+	case errors.Is(err, context.Canceled):
+		pd.Title, pd.Status = "请求已取消", 499
+	}
+
 	_ = pd.JSON(w)
 }
@@ -0,0 +1,378 @@
+package mlink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+	"github.com/vela-ssoc/ssoc-common-mb/dal/model"
+	"github.com/vela-ssoc/ssoc-common-mb/dal/query"
+	"gorm.io/gorm/clause"
+)
+
+var ErrMinionConflict = errors.New("节点身份冲突")
+
+// 同一个 IP 对应了不同主机时的处理策略
+const (
+	ConflictReject = "reject" // 拒绝接入
+	ConflictCreate = "create" // 为新主机新建一条节点记录
+	ConflictRebind = "rebind" // 将原节点记录重新绑定到新主机（默认，与旧版本行为一致）
+)
+
+const (
+	// identityBucket 节点的身份指纹，key 为节点 ID。
+	identityBucket = "ssoc.minion.identity"
+
+	// hostIDBucket agent 上报的主机 ID 与节点 ID 的映射，key 为主机 ID。
+	hostIDBucket = "ssoc.minion.hostid"
+
+	// configBucket broker 运行时配置，由中心端写入。
+	configBucket = "ssoc.broker.config"
+
+	// identityPolicyKey 身份冲突处理策略的配置项，value 为 JSON 字符串，如："reject"。
+	identityPolicyKey = "minion.identity.policy"
+)
+
+// fingerprint 节点的身份指纹。
+type fingerprint struct {
+	HostID   string `json:"host_id"`
+	MAC      string `json:"mac"`
+	Hostname string `json:"hostname"`
+}
+
+// same 判断是否为同一台主机：双方都有主机 ID 时以主机 ID 为准，
+// 否则比较 MAC 和主机名，信息不足以区分时视为同一台主机。
+func (fp fingerprint) same(cur fingerprint) bool {
+	if fp.HostID != "" && cur.HostID != "" {
+		return fp.HostID == cur.HostID
+	}
+	if fp.MAC != "" && cur.MAC != "" && !strings.EqualFold(fp.MAC, cur.MAC) {
+		return false
+	}
+	if fp.Hostname != "" && cur.Hostname != "" && fp.Hostname != cur.Hostname {
+		return false
+	}
+
+	return true
+}
+
+// resolve 根据节点上报的身份信息找到对应的节点记录，找不到时新建。
+//
+// 优先根据主机 ID 查找，这样主机更换 IP 后仍然是原来的节点；
+// 再根据 IP 查找，同一个 IP 下有多条记录时选择指纹匹配的记录。
+// 如果 IP 已被其它主机占用（DHCP 复用、NAT 等），则按照配置的策略处理并产生冲突事件。
+func (hub *minionHub) resolve(ctx context.Context, ident gateway.Ident, inet string, now time.Time) (*model.Minion, error) {
+	fp := fingerprint{HostID: ident.HostID, MAC: ident.MAC, Hostname: ident.Hostname}
+	tbl := hub.qry.Minion
+
+	if fp.HostID != "" {
+		if mid := hub.hostBinding(ctx, fp.HostID); mid != 0 {
+			mon, err := tbl.WithContext(ctx).Where(tbl.ID.Eq(mid)).First()
+			if err == nil && mon.Inet == inet {
+				hub.bindIdentity(ctx, mon.ID, fp)
+				return mon, nil
+			}
+			if err == nil {
+				ret, exx := hub.hostMoved(ctx, mon, ident, inet, fp, now)
+				if exx != nil || ret != nil {
+					return ret, exx
+				}
+			}
+			// 主机 ID 不可信（克隆、伪造）或者新 IP 已被其它节点记录占用，
+			// 不再根据主机 ID 识别，按照 IP 继续查找，也不会改写原节点的主机 ID 绑定。
+			fp.HostID = ""
+		}
+	}
+
+	mons, err := tbl.WithContext(ctx).Where(tbl.Inet.Eq(inet)).Find()
+	if err != nil {
+		return nil, err
+	}
+	if len(mons) == 0 {
+		return hub.create(ctx, ident, inet, now, fp)
+	}
+	for _, mon := range mons {
+		if hub.identity(ctx, mon).same(fp) {
+			hub.bindIdentity(ctx, mon.ID, fp)
+			return mon, nil
+		}
+	}
+
+	mon := mons[0]
+	old := hub.identity(ctx, mon)
+	policy := hub.identityPolicy(ctx)
+	reason := fmt.Sprintf("IP %s 已被其它主机使用，原主机：%s(%s)，新主机：%s(%s)，处理策略：%s",
+		inet, old.Hostname, old.MAC, fp.Hostname, fp.MAC, policy)
+	hub.log.Warn(fmt.Sprintf("节点 %d 身份冲突：%s", mon.ID, reason))
+	hub.phase.Conflicted(mon.ID, ident, policy, reason, now)
+
+	switch policy {
+	case ConflictReject:
+		return nil, ErrMinionConflict
+	case ConflictCreate:
+		return hub.create(ctx, ident, inet, now, fp)
+	default:
+		hub.bindIdentity(ctx, mon.ID, fp)
+		return mon, nil
+	}
+}
+
+// create 新建节点记录。
+func (hub *minionHub) create(ctx context.Context, ident gateway.Ident, inet string, now time.Time, fp fingerprint) (*model.Minion, error) {
+	_, inet6 := ident.Inets()
+	join := &model.Minion{
+		Inet:  inet,
+		Inet6: inet6,
+		// Name:       inet,
+		MAC:    ident.MAC,
+		Goos:   ident.Goos,
+		Arch:   ident.Arch,
+		Status: model.MSOffline,
+		// Semver:     ident.Semver,
+		// CPU:        ident.CPU,
+		// PID:        ident.PID,
+		// Username:   ident.Username,
+		// Hostname:   ident.Hostname,
+		// Workdir:    ident.Workdir,
+		// Executable: ident.Executable,
+		// JoinedAt:   now,
+		Unstable:   ident.Unstable,
+		Customized: ident.Customized,
+		Unload:     ident.Unload,
+		BrokerID:   hub.link.Ident().ID,
+		BrokerName: hub.link.Issue().Name,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := hub.qry.Transaction(func(tx *query.Query) error {
+		if exx := tx.WithContext(ctx).Minion.Create(join); exx != nil {
+			return exx
+		}
+		mid, goos, arch := join.ID, ident.Goos, ident.Arch
+		tags := model.MinionTags{
+			{Tag: goos, MinionID: mid, Kind: model.TkLifelong},
+			{Tag: arch, MinionID: mid, Kind: model.TkLifelong},
+			{Tag: inet, MinionID: mid, Kind: model.TkLifelong},
+		}
+		if inet6 != "" && inet6 != inet {
+			tags = append(tags, &model.MinionTag{Tag: inet6, MinionID: mid, Kind: model.TkLifelong})
+		}
+		return tx.WithContext(ctx).MinionTag.
+			Clauses(clause.OnConflict{DoNothing: true}).
+			Create(tags...)
+	}); err != nil {
+		return nil, err
+	}
+
+	hub.bindIdentity(ctx, join.ID, fp)
+	hub.phase.Created(join.ID, inet, now)
+
+	return join, nil
+}
+
+// hostMoved 主机 ID 相同但 IP 不同：可能是主机更换了 IP，也可能是主机 ID 被克隆（虚拟机镜像）或伪造，
+// 所以按照身份冲突处理。策略为 rebind 且原节点记录不在线、没有有效租约时才修改原记录的 IP，
+// 返回 nil, nil 代表不使用原记录，由调用方按照 IP 继续查找。
+func (hub *minionHub) hostMoved(ctx context.Context, mon *model.Minion, ident gateway.Ident, inet string, fp fingerprint, now time.Time) (*model.Minion, error) {
+	policy := hub.identityPolicy(ctx)
+	live, err := hub.liveSession(ctx, mon)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := fmt.Sprintf("主机 ID %s 已绑定节点 %d(%s)，新连接的 IP 为 %s，处理策略：%s",
+		fp.HostID, mon.ID, mon.Inet, inet, policy)
+	if live {
+		reason += "，原节点在线，不修改其 IP"
+	}
+	hub.log.Warn(fmt.Sprintf("节点 %d 身份冲突：%s", mon.ID, reason))
+	hub.phase.Conflicted(mon.ID, ident, policy, reason, now)
+
+	if policy == ConflictReject {
+		return nil, ErrMinionConflict
+	}
+	if live || policy != ConflictRebind {
+		return nil, nil
+	}
+
+	_, inet6 := ident.Inets()
+	if err = hub.changeInet(ctx, mon, inet, inet6); err != nil {
+		if errors.Is(err, ErrMinionConflict) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	hub.log.Warn(fmt.Sprintf("节点 %d 的 IP 由 %s 变更为 %s", mon.ID, mon.Inet, inet))
+	mon.Inet, mon.Inet6 = inet, inet6
+	hub.bindIdentity(ctx, mon.ID, fp)
+
+	return mon, nil
+}
+
+// liveSession 节点记录是否处于在线状态或者持有未过期的会话租约。
+func (hub *minionHub) liveSession(ctx context.Context, mon *model.Minion) (bool, error) {
+	if mon.Status == model.MSOnline {
+		return true, nil
+	}
+	dat, _, err := hub.leaseHolder(ctx, mon.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return dat != nil && !dat.Expired(time.Now()), nil
+}
+
+// changeInet 主机更换 IP 后修改节点记录的 IP、IPv6 和对应的永久标签。
+// 新 IP 已被其它记录使用或者节点已经上线时返回 ErrMinionConflict。
+func (hub *minionHub) changeInet(ctx context.Context, mon *model.Minion, inet, inet6 string) error {
+	return hub.qry.Transaction(func(tx *query.Query) error {
+		tbl := tx.Minion
+		cnt, err := tbl.WithContext(ctx).Where(tbl.Inet.Eq(inet)).Count()
+		if err != nil {
+			return err
+		}
+		if cnt != 0 {
+			return ErrMinionConflict
+		}
+		ret, err := tbl.WithContext(ctx).
+			Where(tbl.ID.Eq(mon.ID), tbl.Inet.Eq(mon.Inet), tbl.Status.Neq(uint8(model.MSOnline))).
+			UpdateSimple(tbl.Inet.Value(inet), tbl.Inet6.Value(inet6))
+		if err != nil {
+			return err
+		}
+		if ret.RowsAffected == 0 {
+			return ErrMinionConflict
+		}
+
+		tag := tx.MinionTag
+		if _, err = tag.WithContext(ctx).
+			Where(tag.MinionID.Eq(mon.ID), tag.Tag.Eq(mon.Inet)).
+			UpdateSimple(tag.Tag.Value(inet)); err != nil {
+			return err
+		}
+		if mon.Inet6 == inet6 {
+			return nil
+		}
+		if mon.Inet6 != "" && mon.Inet6 != inet {
+			if _, err = tag.WithContext(ctx).
+				Where(tag.MinionID.Eq(mon.ID), tag.Tag.Eq(mon.Inet6)).
+				Delete(); err != nil {
+				return err
+			}
+		}
+		if inet6 == "" || inet6 == inet {
+			return nil
+		}
+
+		return tag.WithContext(ctx).
+			Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&model.MinionTag{Tag: inet6, MinionID: mon.ID, Kind: model.TkLifelong})
+	})
+}
+
+// identity 查询节点记录的身份指纹，没有记录时以节点表中的 MAC 作为指纹。
+func (hub *minionHub) identity(ctx context.Context, mon *model.Minion) fingerprint {
+	fp := fingerprint{MAC: mon.MAC}
+	tbl := hub.qry.KVData
+	key := strconv.FormatInt(mon.ID, 10)
+	if dat, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(identityBucket), tbl.Key.Eq(key)).
+		First(); err == nil {
+		_ = json.Unmarshal(dat.Value, &fp)
+	}
+
+	return fp
+}
+
+// hostBinding 根据主机 ID 查询绑定的节点 ID。
+func (hub *minionHub) hostBinding(ctx context.Context, hostID string) int64 {
+	tbl := hub.qry.KVData
+	dat, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(hostIDBucket), tbl.Key.Eq(hostID)).
+		First()
+	if err != nil {
+		return 0
+	}
+	var mid int64
+	_ = json.Unmarshal(dat.Value, &mid)
+
+	return mid
+}
+
+// bindIdentity 保存节点的身份指纹和主机 ID 映射。
+func (hub *minionHub) bindIdentity(ctx context.Context, mid int64, fp fingerprint) {
+	now := time.Now()
+	val, _ := json.Marshal(fp)
+	dats := []*model.KVData{{
+		Bucket:    identityBucket,
+		Key:       strconv.FormatInt(mid, 10),
+		Value:     val,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}}
+	if fp.HostID != "" {
+		sid, _ := json.Marshal(mid)
+		dats = append(dats, &model.KVData{
+			Bucket:    hostIDBucket,
+			Key:       fp.HostID,
+			Value:     sid,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Version:   1,
+		})
+	}
+
+	tbl := hub.qry.KVData
+	if err := tbl.WithContext(ctx).
+		Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"})}).
+		Create(dats...); err != nil {
+		hub.log.Warn(fmt.Sprintf("保存节点 %d 的身份指纹出错：%v", mid, err))
+	}
+}
+
+// identityPolicy 读取身份冲突处理策略，结果缓存一分钟。
+func (hub *minionHub) identityPolicy(ctx context.Context) string {
+	hub.policyMu.Lock()
+	defer hub.policyMu.Unlock()
+
+	now := time.Now()
+	if now.Before(hub.policyAt.Add(time.Minute)) {
+		return hub.policy
+	}
+
+	policy := ConflictRebind
+	var val string
+	if hub.runtimeConfig(ctx, identityPolicyKey, &val) == nil {
+		switch val {
+		case ConflictReject, ConflictCreate, ConflictRebind:
+			policy = val
+		}
+	}
+	hub.policy, hub.policyAt = policy, now
+
+	return policy
+}
+
+// runtimeConfig 读取中心端写入的 broker 运行时配置，并解析到 v 中。
+func (hub *minionHub) runtimeConfig(ctx context.Context, key string, v any) error {
+	return runtimeConfig(ctx, hub.qry, key, v)
+}
+
+func runtimeConfig(ctx context.Context, qry *query.Query, key string, v any) error {
+	tbl := qry.KVData
+	dat, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(configBucket), tbl.Key.Eq(key)).
+		First()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(dat.Value, v)
+}
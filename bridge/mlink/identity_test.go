@@ -0,0 +1,28 @@
+package mlink
+
+import "testing"
+
+func TestFingerprintSame(t *testing.T) {
+	tests := []struct {
+		name string
+		old  fingerprint
+		cur  fingerprint
+		want bool
+	}{
+		{"主机 ID 相同", fingerprint{HostID: "a", MAC: "m1"}, fingerprint{HostID: "a", MAC: "m2"}, true},
+		{"主机 ID 不同", fingerprint{HostID: "a", MAC: "m1"}, fingerprint{HostID: "b", MAC: "m1"}, false},
+		{"一方没有主机 ID 时比较 MAC", fingerprint{HostID: "a", MAC: "m1"}, fingerprint{MAC: "m2"}, false},
+		{"MAC 不区分大小写", fingerprint{MAC: "AA:BB"}, fingerprint{MAC: "aa:bb"}, true},
+		{"MAC 相同主机名不同", fingerprint{MAC: "m1", Hostname: "h1"}, fingerprint{MAC: "m1", Hostname: "h2"}, false},
+		{"缺少 MAC 时比较主机名", fingerprint{Hostname: "h1"}, fingerprint{MAC: "m1", Hostname: "h1"}, true},
+		{"信息不足视为同一台主机", fingerprint{}, fingerprint{MAC: "m1", Hostname: "h1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.old.same(tt.cur); got != tt.want {
+				t.Errorf("same() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package mlink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-common-mb/dal/model"
+	"gorm.io/gorm"
+)
+
+// leaseBucket 节点会话租约在 kv_data 中使用的 bucket，key 为节点 ID。
+//
+// 每个 broker 定期为自己的在线节点续租，broker 宕机后租约自然过期，
+// 节点就可以连接到其它 broker，不需要人工修改数据库。
+// kv_data 的 version 字段作为防护令牌（fencing token），每次租约易主都会加一，
+// 续租时发现 version 与自己持有的令牌不一致，说明会话已被其它 broker 接管，需要主动断开。
+const leaseBucket = "ssoc.minion.lease"
+
+const (
+	leaseTTL   = 90 * time.Second // 租约有效期
+	leaseRenew = 30 * time.Second // 续租周期
+	leaseBatch = 500              // 批量续租时每批的节点数
+)
+
+// leaseValue 租约的持有者信息。
+type leaseValue struct {
+	BrokerID   int64     `json:"broker_id"`
+	BrokerName string    `json:"broker_name"`
+	Inet       string    `json:"inet"`
+	JoinedAt   time.Time `json:"joined_at"`
+}
+
+// leaseHolder 查询节点的租约，租约不存在时返回 nil。
+func (hub *minionHub) leaseHolder(ctx context.Context, id int64) (*model.KVData, *leaseValue, error) {
+	tbl := hub.qry.KVData
+	key := strconv.FormatInt(id, 10)
+	dat, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(leaseBucket), tbl.Key.Eq(key)).
+		First()
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	val := new(leaseValue)
+	_ = json.Unmarshal(dat.Value, val)
+
+	return dat, val, nil
+}
+
+// leaseHeld 在线状态的节点是否仍然被其它会话持有。
+// 没有租约的视为旧版本 broker 连接的节点，认为仍然被持有；租约过期说明原来的 broker 已经宕机；
+// 当前 broker 持有的租约如果本地已经没有对应的连接，说明是上次运行遗留的，都可以接管。
+func (hub *minionHub) leaseHeld(ctx context.Context, id int64) (bool, error) {
+	dat, val, err := hub.leaseHolder(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if dat == nil {
+		return true, nil
+	}
+	if dat.Expired(time.Now()) {
+		return false, nil
+	}
+	if val.BrokerID != hub.bid {
+		return true, nil
+	}
+	sid := strconv.FormatInt(id, 10)
+
+	return hub.section.Get(sid) != nil, nil
+}
+
+// acquireLease 获取节点的会话租约，返回防护令牌。
+// 只有租约不存在、已过期或者是当前 broker 遗留的租约才能获取成功，
+// 多个 broker 同时抢占时依靠 version 的 CAS 保证只有一个能成功。
+func (hub *minionHub) acquireLease(ctx context.Context, id int64, inet string, at time.Time) (int64, error) {
+	dat, val, err := hub.leaseHolder(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	if dat != nil && !dat.Expired(now) && val.BrokerID != hub.bid {
+		return 0, ErrMinionOnline
+	}
+
+	owner := &leaseValue{BrokerID: hub.bid, BrokerName: hub.link.Issue().Name, Inet: inet, JoinedAt: at}
+	raw, _ := json.Marshal(owner)
+	tbl := hub.qry.KVData
+	if dat == nil {
+		lease := &model.KVData{
+			Bucket:    leaseBucket,
+			Key:       strconv.FormatInt(id, 10),
+			Value:     raw,
+			Lifetime:  leaseTTL,
+			ExpiredAt: now.Add(leaseTTL),
+			CreatedAt: now,
+			UpdatedAt: now,
+			Version:   1,
+		}
+		if err = tbl.WithContext(ctx).Create(lease); err != nil {
+			// 主键冲突说明被其它 broker 抢先获取，其它错误（数据库不可用等）原样返回。
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return 0, ErrMinionOnline
+			}
+			if exist, _, exx := hub.leaseHolder(ctx, id); exx == nil && exist != nil {
+				return 0, ErrMinionOnline
+			}
+			return 0, err
+		}
+		return lease.Version, nil
+	}
+
+	token := dat.Version + 1
+	info, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(leaseBucket), tbl.Key.Eq(dat.Key), tbl.Version.Eq(dat.Version)).
+		UpdateSimple(
+			tbl.Value.Value(raw),
+			tbl.Lifetime.Value(int64(leaseTTL)),
+			tbl.ExpiredAt.Value(now.Add(leaseTTL)),
+			tbl.Version.Value(token),
+		)
+	if err != nil {
+		return 0, err
+	}
+	if info.RowsAffected == 0 {
+		return 0, ErrMinionOnline
+	}
+	if dat.Expired(now) && val.BrokerID != hub.bid {
+		hub.log.Warn(fmt.Sprintf("节点 %s(%d) 的租约已过期，从 broker %s(%d) 接管会话",
+			inet, id, val.BrokerName, val.BrokerID))
+	}
+
+	return token, nil
+}
+
+// releaseLease 节点断开后释放租约，令牌不一致说明已被其它 broker 接管，不做处理。
+func (hub *minionHub) releaseLease(ctx context.Context, id, token int64) {
+	tbl := hub.qry.KVData
+	key := strconv.FormatInt(id, 10)
+	_, _ = tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(leaseBucket), tbl.Key.Eq(key), tbl.Version.Eq(token)).
+		Delete()
+}
+
+// renewLeases 周期性的为本地所有在线节点续租，parent 结束后退出。
+func (hub *minionHub) renewLeases(parent context.Context) {
+	ticker := time.NewTicker(leaseRenew)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-parent.Done():
+			return
+		case <-ticker.C:
+		}
+
+		conns := hub.section.Conns()
+		for i := 0; i < len(conns); i += leaseBatch {
+			hub.renewBatch(parent, conns[i:min(i+leaseBatch, len(conns))])
+		}
+	}
+}
+
+// renewBatch 按照各个会话持有的防护令牌续租：持有相同令牌的会话用一条 UPDATE 批量续期，
+// 只续期令牌一致的租约，已经失去租约的 broker 不会延长新持有者的租约。
+// 更新到的记录比会话少时，查出令牌不一致的会话（已被其它 broker 接管）主动断开。
+// 某一组续租出错时记录日志后继续续租其它组，不影响其它节点的租约。
+func (hub *minionHub) renewBatch(parent context.Context, conns []*connect) {
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	defer cancel()
+
+	groups := make(map[int64]map[string]*connect, 8) // 令牌 -> 节点 ID -> 会话
+	for _, c := range conns {
+		held := c.token.Load()
+		if held == 0 { // 刚刚加入还未获取到租约
+			continue
+		}
+		group := groups[held]
+		if group == nil {
+			group = make(map[string]*connect, len(conns))
+			groups[held] = group
+		}
+		group[strconv.FormatInt(c.id, 10)] = c
+	}
+
+	tbl := hub.qry.KVData
+	expiredAt := time.Now().Add(leaseTTL)
+	for token, group := range groups {
+		keys := make([]string, 0, len(group))
+		for key := range group {
+			keys = append(keys, key)
+		}
+		ret, err := tbl.WithContext(ctx).
+			Where(tbl.Bucket.Eq(leaseBucket), tbl.Key.In(keys...), tbl.Version.Eq(token)).
+			UpdateSimple(tbl.ExpiredAt.Value(expiredAt))
+		if err != nil {
+			hub.log.Warn(fmt.Sprintf("节点租约续期失败，令牌 %d，节点 %v：%v", token, keys, err))
+			continue
+		}
+		if int(ret.RowsAffected) >= len(keys) {
+			continue
+		}
+
+		// 部分租约已经易主，查出仍然持有的租约，断开其余的会话。
+		helds, err := tbl.WithContext(ctx).
+			Select(tbl.Key).
+			Where(tbl.Bucket.Eq(leaseBucket), tbl.Key.In(keys...), tbl.Version.Eq(token)).
+			Find()
+		if err != nil {
+			hub.log.Warn(fmt.Sprintf("查询节点租约失败，令牌 %d，节点 %v：%v", token, keys, err))
+			continue
+		}
+		for _, dat := range helds {
+			delete(group, dat.Key)
+		}
+		for _, c := range group {
+			hub.log.Warn(fmt.Sprintf("节点 %s(%d) 的会话租约已失效，断开连接", c.Inet(), c.id))
+			_ = c.mux.Close()
+		}
+	}
+}
+
+// dropLeases 删除当前 broker 遗留的租约。
+func (hub *minionHub) dropLeases(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, strconv.FormatInt(id, 10))
+	}
+
+	tbl := hub.qry.KVData
+	_, err := tbl.WithContext(ctx).
+		Where(tbl.Bucket.Eq(leaseBucket), tbl.Key.In(keys...)).
+		Delete()
+
+	return err
+}
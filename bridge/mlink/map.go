@@ -7,6 +7,7 @@ type container interface {
 	Get(id string) *connect
 	Del(id string) *connect
 	IDs() []int64
+	Conns() []*connect
 }
 
 type Iter interface {
@@ -114,6 +115,15 @@ func (sm *segmentMap) IDs() []int64 {
 	return ret
 }
 
+func (sm *segmentMap) Conns() []*connect {
+	ret := make([]*connect, 0, 2000)
+	for _, c := range sm.slot {
+		ret = append(ret, c.connections()...)
+	}
+
+	return ret
+}
+
 // getSLOT 根据 key 计算所在的存储桶
 func (sm *segmentMap) getSLOT(key string) *safeMap {
 	hash := sm.fnv32(key)
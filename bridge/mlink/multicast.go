@@ -0,0 +1,117 @@
+package mlink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vela-ssoc/vela-common-mba/netutil"
+)
+
+const (
+	multicastParallel = 64               // 多播的最大并发数
+	multicastTimeout  = 30 * time.Second // 多播时单个节点的超时时间
+)
+
+// MulticastResult 多播时单个节点的执行结果。
+type MulticastResult struct {
+	ID      int64         `json:"id"`              // 节点 ID
+	Status  int           `json:"status"`          // 节点响应的状态码，请求未送达时为 0
+	Latency time.Duration `json:"latency"`         // 请求耗时
+	Error   string        `json:"error,omitempty"` // 错误信息
+}
+
+// Succeed 节点是否处理成功。
+func (mr *MulticastResult) Succeed() bool {
+	return mr.Error == ""
+}
+
+// Multicast 并发的向多个节点发送同一条消息，每个节点处理完毕就通过 channel 返回结果，
+// 全部节点处理完毕后关闭 channel。ctx 取消后不再派发新的节点，已派发的节点结果无人读取时直接丢弃，
+// channel 随后关闭，因此调用方中途停止读取时应当取消 ctx。
+func (hub *minionHub) Multicast(ctx context.Context, ids []int64, path string, body any) <-chan *MulticastResult {
+	ret := make(chan *MulticastResult, multicastParallel)
+	raw, err := json.Marshal(body)
+	if err != nil {
+		go func() {
+			defer close(ret)
+			for _, id := range ids {
+				select {
+				case ret <- &MulticastResult{ID: id, Error: err.Error()}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ret
+	}
+
+	go func() {
+		var wg sync.WaitGroup
+		limit := make(chan struct{}, multicastParallel)
+	dispatch:
+		for _, id := range ids {
+			select {
+			case limit <- struct{}{}:
+			case <-ctx.Done():
+				break dispatch
+			}
+			wg.Add(1)
+			go func(id int64) {
+				defer func() {
+					<-limit
+					wg.Done()
+				}()
+				res := hub.multicast(ctx, id, path, raw)
+				select {
+				case ret <- res:
+				case <-ctx.Done():
+				}
+			}(id)
+		}
+		wg.Wait()
+		close(ret)
+	}()
+
+	return ret
+}
+
+func (hub *minionHub) multicast(parent context.Context, id int64, path string, raw []byte) *MulticastResult {
+	ctx, cancel := context.WithTimeout(parent, multicastTimeout)
+	defer cancel()
+
+	start := time.Now()
+	res := &MulticastResult{ID: id}
+	addr := hub.httpURL(id, path)
+	header := http.Header{"Content-Type": []string{"application/json; charset=utf-8"}}
+	req, err := hub.client.NewRequest(ctx, http.MethodPost, addr, bytes.NewReader(raw), header)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	resp, err := hub.client.Do(req)
+	res.Latency = time.Since(start)
+	if err != nil {
+		var he *netutil.HTTPError
+		if errors.As(err, &he) {
+			res.Status = he.Code
+		}
+		res.Error = err.Error()
+		return res
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	res.Status = resp.StatusCode
+	if code := resp.StatusCode; code < 200 || code >= 300 {
+		res.Error = http.StatusText(code)
+	}
+
+	return res
+}
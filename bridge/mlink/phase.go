@@ -1,21 +1,46 @@
 package mlink
 
 import (
+	"context"
 	"time"
 
 	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
 )
 
-type NodePhaser interface {
-	// Created 新节点注册
-	Created(id int64, inet string, at time.Time)
+// 节点生命周期事件类型
+const (
+	PhaseCreated      = "created"      // 新节点注册
+	PhaseRepeated     = "repeated"     // 节点重复登录
+	PhaseConflicted   = "conflicted"   // 节点身份冲突，即同一个 IP 对应了不同的主机
+	PhaseConnected    = "connected"    // 节点连接成功
+	PhaseDisconnected = "disconnected" // 节点断开连接
+	PhaseRefused      = "refused"      // 节点未通过准入检查
+	PhaseMismatched   = "mismatched"   // 节点声明的 inet 与来源地址不一致
+	PhaseReconciled   = "reconciled"   // 周期校对时发现并修正了数据库与内存会话不一致的节点状态
+	PhaseFlapping     = "flapping"     // 节点频繁断开重连，每个统计窗口最多产生一次
+)
 
-	// Repeated 节点重复登录
-	Repeated(id int64, ident gateway.Ident, at time.Time)
+// PhaseEvent 节点生命周期事件。
+type PhaseEvent struct {
+	Type     string         `json:"type"`               // 事件类型
+	MinionID int64          `json:"minion_id"`          // 节点 ID，未通过准入检查等情况下为 0
+	Inet     string         `json:"inet"`               // 节点 IP
+	Broker   string         `json:"broker"`             // 产生事件的 broker
+	Ident    *gateway.Ident `json:"ident,omitempty"`    // 节点上报的身份信息
+	Peer     string         `json:"peer,omitempty"`     // 节点的来源地址
+	Policy   string         `json:"policy,omitempty"`   // 身份冲突时采取的处理策略
+	Reason   string         `json:"reason,omitempty"`   // 原因说明
+	Duration time.Duration  `json:"duration,omitempty"` // 断开连接时为本次在线时长，闪断时为统计窗口
+	Count    int            `json:"count,omitempty"`    // 闪断次数
+	At       time.Time      `json:"at"`                 // 事件发生时间
+}
 
-	// Connected 节点连接成功
-	Connected(lnk Linker, ident gateway.Ident, issue gateway.Issue, at time.Time)
+// PhaseSubscriber 生命周期事件的订阅者，如果同时实现了 io.Closer，
+// 事件总线关闭时会在队列处理完毕后调用 Close。
+type PhaseSubscriber interface {
+	// Name 订阅者名字，用于日志。
+	Name() string
 
-	// Disconnected 节点断开连接
-	Disconnected(lnk Linker, ident gateway.Ident, issue gateway.Issue, at time.Time, du time.Duration)
+	// Handle 处理事件，同一个订阅者的事件按照发生顺序串行处理。
+	Handle(ctx context.Context, evt *PhaseEvent) error
 }
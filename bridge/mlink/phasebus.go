@@ -0,0 +1,193 @@
+package mlink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+)
+
+// PhaseBus 节点生命周期事件总线：hub 只负责构造事件并发布一次。
+//
+// 告警、事件入库以及外部系统通知是总线的订阅者，每个订阅者都有独立的有界队列和协程，
+// 队列满时丢弃事件，处理慢的订阅者不会阻塞 hub；下发配置等不能丢失的动作注册为钩子，
+// 在发布事件时同步调用。
+type PhaseBus struct {
+	broker string
+	log    *slog.Logger
+	mutex  sync.RWMutex
+	closed bool
+	hooks  []*phaseHook
+	subs   []*subscription
+	wg     sync.WaitGroup
+}
+
+type phaseHook struct {
+	fn    func(*PhaseEvent)
+	types []string
+}
+
+func NewPhaseBus(broker string, log *slog.Logger) *PhaseBus {
+	return &PhaseBus{
+		broker: broker,
+		log:    log,
+	}
+}
+
+// Subscribe 注册订阅者，size 为队列长度（默认 1024），types 为关注的事件类型，为空代表全部。
+func (pb *PhaseBus) Subscribe(sub PhaseSubscriber, size int, types ...string) {
+	if size <= 0 {
+		size = 1024
+	}
+	s := &subscription{
+		sub:   sub,
+		types: types,
+		queue: make(chan *PhaseEvent, size),
+		log:   pb.log,
+	}
+
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+	if pb.closed {
+		if c, ok := sub.(io.Closer); ok {
+			_ = c.Close()
+		}
+		return
+	}
+	pb.subs = append(pb.subs, s)
+	pb.wg.Add(1)
+	go func() {
+		defer pb.wg.Done()
+		s.serve()
+	}()
+}
+
+// Hook 注册同步钩子，types 为关注的事件类型，为空代表全部。钩子在发布事件的协程中直接调用，
+// 不经过队列也不会被丢弃，所以钩子不能阻塞，耗时的操作应该交给有界的协程池处理。
+func (pb *PhaseBus) Hook(fn func(*PhaseEvent), types ...string) {
+	pb.mutex.Lock()
+	pb.hooks = append(pb.hooks, &phaseHook{fn: fn, types: types})
+	pb.mutex.Unlock()
+}
+
+// Close 关闭事件总线：不再接收新事件，等待所有订阅者处理完队列中的事件后，
+// 关闭实现了 io.Closer 的订阅者。
+func (pb *PhaseBus) Close() error {
+	pb.mutex.Lock()
+	if pb.closed {
+		pb.mutex.Unlock()
+		return nil
+	}
+	pb.closed = true
+	subs := pb.subs
+	for _, s := range subs {
+		close(s.queue)
+	}
+	pb.mutex.Unlock()
+
+	pb.wg.Wait()
+	var errs []error
+	for _, s := range subs {
+		if c, ok := s.sub.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.sub.Name(), err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (pb *PhaseBus) publish(evt *PhaseEvent) {
+	evt.Broker = pb.broker
+
+	pb.mutex.RLock()
+	defer pb.mutex.RUnlock()
+	if pb.closed {
+		return
+	}
+	for _, h := range pb.hooks {
+		if len(h.types) == 0 || slices.Contains(h.types, evt.Type) {
+			h.fn(evt)
+		}
+	}
+	for _, s := range pb.subs {
+		s.offer(evt)
+	}
+}
+
+func (pb *PhaseBus) Created(id int64, inet string, at time.Time) {
+	pb.publish(&PhaseEvent{Type: PhaseCreated, MinionID: id, Inet: inet, At: at})
+}
+
+func (pb *PhaseBus) Repeated(id int64, ident gateway.Ident, at time.Time) {
+	pb.publish(&PhaseEvent{Type: PhaseRepeated, MinionID: id, Inet: ident.Inet.String(), Ident: &ident, At: at})
+}
+
+func (pb *PhaseBus) Conflicted(id int64, ident gateway.Ident, policy, reason string, at time.Time) {
+	pb.publish(&PhaseEvent{Type: PhaseConflicted, MinionID: id, Inet: ident.Inet.String(), Ident: &ident, Policy: policy, Reason: reason, At: at})
+}
+
+func (pb *PhaseBus) Connected(ident gateway.Ident, issue gateway.Issue, at time.Time) {
+	pb.publish(&PhaseEvent{Type: PhaseConnected, MinionID: issue.ID, Inet: ident.Inet.String(), Ident: &ident, At: at})
+}
+
+func (pb *PhaseBus) Disconnected(ident gateway.Ident, issue gateway.Issue, at time.Time, du time.Duration) {
+	pb.publish(&PhaseEvent{Type: PhaseDisconnected, MinionID: issue.ID, Inet: ident.Inet.String(), Ident: &ident, Duration: du, At: at})
+}
+
+func (pb *PhaseBus) Refused(ident gateway.Ident, peer, reason string, at time.Time) {
+	pb.publish(&PhaseEvent{Type: PhaseRefused, Inet: ident.Inet.String(), Ident: &ident, Peer: peer, Reason: reason, At: at})
+}
+
+func (pb *PhaseBus) Mismatched(ident gateway.Ident, peer string, at time.Time) {
+	pb.publish(&PhaseEvent{Type: PhaseMismatched, Inet: ident.Inet.String(), Ident: &ident, Peer: peer, At: at})
+}
+
+func (pb *PhaseBus) Reconciled(id int64, inet, reason string, at time.Time) {
+	pb.publish(&PhaseEvent{Type: PhaseReconciled, MinionID: id, Inet: inet, Reason: reason, At: at})
+}
+
+func (pb *PhaseBus) Flapping(id int64, ident gateway.Ident, count int, window time.Duration, at time.Time) {
+	pb.publish(&PhaseEvent{Type: PhaseFlapping, MinionID: id, Inet: ident.Inet.String(), Ident: &ident, Count: count, Duration: window, At: at})
+}
+
+type subscription struct {
+	sub     PhaseSubscriber
+	types   []string
+	queue   chan *PhaseEvent
+	dropped atomic.Int64
+	log     *slog.Logger
+}
+
+func (s *subscription) offer(evt *PhaseEvent) {
+	if len(s.types) != 0 && !slices.Contains(s.types, evt.Type) {
+		return
+	}
+
+	select {
+	case s.queue <- evt:
+	default:
+		// 队列满了只在第 1、1001、2001... 次丢弃时打印日志，防止日志刷屏。
+		if n := s.dropped.Add(1); n%1000 == 1 {
+			s.log.Warn(fmt.Sprintf("生命周期事件订阅者 %s 处理太慢，已累计丢弃 %d 个事件", s.sub.Name(), n))
+		}
+	}
+}
+
+func (s *subscription) serve() {
+	for evt := range s.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := s.sub.Handle(ctx, evt); err != nil {
+			s.log.Warn(fmt.Sprintf("生命周期事件订阅者 %s 处理 %s 事件出错：%v", s.sub.Name(), evt.Type, err))
+		}
+		cancel()
+	}
+}
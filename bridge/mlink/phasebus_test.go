@@ -0,0 +1,138 @@
+package mlink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+)
+
+type recordSubscriber struct {
+	events []*PhaseEvent
+	closed bool
+}
+
+func (rs *recordSubscriber) Name() string { return "record" }
+
+func (rs *recordSubscriber) Handle(_ context.Context, evt *PhaseEvent) error {
+	rs.events = append(rs.events, evt)
+	return nil
+}
+
+func (rs *recordSubscriber) Close() error {
+	rs.closed = true
+	return nil
+}
+
+func TestPhaseBusClose(t *testing.T) {
+	pb := NewPhaseBus("broker", slog.Default())
+	all, flaps := new(recordSubscriber), new(recordSubscriber)
+	pb.Subscribe(all, 16)
+	pb.Subscribe(flaps, 16, PhaseFlapping)
+
+	ident := gateway.Ident{Hostname: "host"}
+	pb.Connected(ident, gateway.Issue{ID: 1}, time.Now())
+	pb.Flapping(1, ident, 5, time.Minute, time.Now())
+	pb.Conflicted(1, ident, "reject", "主机不一致", time.Now())
+	if err := pb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pb.Created(2, "10.0.0.2", time.Now()) // 关闭后发布的事件直接丢弃
+
+	if len(all.events) != 3 || len(flaps.events) != 1 {
+		t.Fatalf("events = %d %d, want 3 1", len(all.events), len(flaps.events))
+	}
+	if evt := all.events[2]; evt.Broker != "broker" || evt.Policy != "reject" || evt.Ident.Hostname != "host" {
+		t.Errorf("conflicted = %+v", evt)
+	}
+	if !all.closed || !flaps.closed {
+		t.Error("关闭总线时应该关闭订阅者")
+	}
+
+	late := new(recordSubscriber)
+	pb.Subscribe(late, 16)
+	if !late.closed {
+		t.Error("关闭后注册的订阅者应该直接关闭")
+	}
+}
+
+type blockSubscriber struct {
+	release chan struct{}
+}
+
+func (bs *blockSubscriber) Name() string { return "block" }
+
+func (bs *blockSubscriber) Handle(context.Context, *PhaseEvent) error {
+	<-bs.release
+	return nil
+}
+
+func TestPhaseBusHook(t *testing.T) {
+	pb := NewPhaseBus("broker", slog.Default())
+	var connected []int64
+	pb.Hook(func(evt *PhaseEvent) { connected = append(connected, evt.MinionID) }, PhaseConnected)
+	block := &blockSubscriber{release: make(chan struct{})}
+	pb.Subscribe(block, 1)
+
+	// 订阅者队列早已满了，钩子仍然要收到每一个事件
+	for i := range 100 {
+		pb.Connected(gateway.Ident{}, gateway.Issue{ID: int64(i)}, time.Now())
+		pb.Disconnected(gateway.Ident{}, gateway.Issue{ID: int64(i)}, time.Now(), time.Second)
+	}
+	close(block.release)
+	_ = pb.Close()
+
+	if len(connected) != 100 || connected[99] != 99 {
+		t.Errorf("hook 收到 %d 个事件, want 100", len(connected))
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phase", "events.ndjson")
+	sink, err := NewFileSink("file", path, 256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		if err = sink.Handle(context.Background(), &PhaseEvent{Type: PhaseCreated, MinionID: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sink.(interface{ Close() error }).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Handle(context.Background(), &PhaseEvent{}); err == nil {
+		t.Error("关闭后写入应该报错")
+	}
+
+	if _, err = os.Stat(path + ".2"); err == nil {
+		t.Error("最多保留 2 个文件")
+	}
+	var last int64 = -1
+	for _, name := range []string{path + ".1", path} {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc := bufio.NewScanner(file)
+		for sc.Scan() {
+			evt := new(PhaseEvent)
+			if err = json.Unmarshal(sc.Bytes(), evt); err != nil {
+				t.Errorf("%s 不是完整的 JSON 行：%s", filepath.Base(name), sc.Text())
+			} else if evt.MinionID <= last {
+				t.Errorf("事件顺序错误：%d 在 %d 之后", evt.MinionID, last)
+			}
+			last = evt.MinionID
+		}
+		_ = file.Close()
+	}
+	if last != 9 {
+		t.Errorf("最后一个事件 = %d, want 9", last)
+	}
+}
@@ -0,0 +1,184 @@
+package mlink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-common-mb/dal/query"
+	"gorm.io/gorm"
+)
+
+// phaseSinksKey 生命周期事件订阅配置项，value 为 []PhaseSinkConfig 的 JSON。
+const phaseSinksKey = "minion.phase.sinks"
+
+// 内置的订阅者类型
+const (
+	SinkFile    = "file"    // 以 NDJSON 格式追加写入本地文件
+	SinkWebhook = "webhook" // 以 JSON 格式 POST 到 HTTP 地址
+)
+
+// PhaseSinkConfig 内置订阅者的配置，由中心端写入 broker 运行时配置。
+type PhaseSinkConfig struct {
+	Name     string            `json:"name"`      // 订阅者名字
+	Kind     string            `json:"kind"`      // 订阅者类型：file webhook
+	Path     string            `json:"path"`      // file 类型的文件路径
+	MaxSize  int64             `json:"max_size"`  // file 类型单个文件的大小上限，默认 64MiB，超过后滚动
+	MaxFiles int               `json:"max_files"` // file 类型最多保留的文件个数（包括当前文件），默认 5
+	URL      string            `json:"url"`       // webhook 类型的请求地址
+	Header   map[string]string `json:"header"`    // webhook 类型的请求头，如：鉴权 token
+	Types    []string          `json:"types"`     // 关注的事件类型，为空代表全部
+	Queue    int               `json:"queue"`     // 队列长度，默认 1024
+}
+
+// Load 从 broker 运行时配置中加载内置的订阅者，没有配置时不做任何处理。
+func (pb *PhaseBus) Load(ctx context.Context, qry *query.Query) error {
+	var cfgs []*PhaseSinkConfig
+	if err := runtimeConfig(ctx, qry, phaseSinksKey, &cfgs); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	for _, cfg := range cfgs {
+		var sub PhaseSubscriber
+		switch cfg.Kind {
+		case SinkFile:
+			sink, err := NewFileSink(cfg.Name, cfg.Path, cfg.MaxSize, cfg.MaxFiles)
+			if err != nil {
+				pb.log.Warn(fmt.Sprintf("生命周期事件订阅者 %s 初始化失败：%v", cfg.Name, err))
+				continue
+			}
+			sub = sink
+		case SinkWebhook:
+			header := make(http.Header, len(cfg.Header))
+			for k, v := range cfg.Header {
+				header.Set(k, v)
+			}
+			sub = NewWebhookSink(cfg.Name, cfg.URL, header)
+		default:
+			pb.log.Warn(fmt.Sprintf("生命周期事件订阅者 %s 的类型 %s 不支持", cfg.Name, cfg.Kind))
+			continue
+		}
+		pb.Subscribe(sub, cfg.Queue, cfg.Types...)
+		pb.log.Info(fmt.Sprintf("已注册生命周期事件订阅者 %s(%s)", cfg.Name, cfg.Kind))
+	}
+
+	return nil
+}
+
+// NewFileSink 将事件以 NDJSON（每行一个 JSON）格式追加写入本地文件，文件超过 maxSize（默认 64MiB）
+// 后滚动，最多保留 maxFiles（默认 5）个文件。
+func NewFileSink(name, path string, maxSize int64, maxFiles int) (PhaseSubscriber, error) {
+	if maxSize <= 0 {
+		maxSize = 64 * 1024 * 1024
+	}
+	if maxFiles <= 0 {
+		maxFiles = 5
+	}
+	file, err := openRotateFile(path, maxSize, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSink{name: name, file: file}, nil
+}
+
+type fileSink struct {
+	name  string
+	mutex sync.Mutex
+	file  *rotateFile
+}
+
+func (fs *fileSink) Name() string { return fs.name }
+
+func (fs *fileSink) Handle(_ context.Context, evt *PhaseEvent) error {
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	_, err = fs.file.Write(raw)
+
+	return err
+}
+
+func (fs *fileSink) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.file.Close()
+}
+
+// NewWebhookSink 将事件以 JSON 格式 POST 到 HTTP 地址，网络错误或 5xx 时最多重试 3 次。
+func NewWebhookSink(name, addr string, header http.Header) PhaseSubscriber {
+	return &webhookSink{
+		name:   name,
+		addr:   addr,
+		header: header,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type webhookSink struct {
+	name   string
+	addr   string
+	header http.Header
+	client *http.Client
+}
+
+func (ws *webhookSink) Name() string { return ws.name }
+
+func (ws *webhookSink) Handle(ctx context.Context, evt *PhaseEvent) error {
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		var retry bool
+		if retry, err = ws.send(ctx, raw); err == nil || !retry || i >= 2 {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(i+1) * time.Second):
+		}
+	}
+}
+
+func (ws *webhookSink) send(ctx context.Context, raw []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ws.addr, bytes.NewReader(raw))
+	if err != nil {
+		return false, err
+	}
+	for k, vs := range ws.header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	res, err := ws.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	_ = res.Body.Close()
+
+	if code := res.StatusCode; code < 200 || code >= 300 {
+		return code >= 500, fmt.Errorf("webhook 响应状态码 %d", code)
+	}
+
+	return false, nil
+}
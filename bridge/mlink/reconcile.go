@@ -0,0 +1,204 @@
+package mlink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-common-mb/dal/model"
+	"gorm.io/gorm"
+)
+
+// reconcileKey 状态校对的配置项，value 为 ReconcileConfig 的 JSON。
+const reconcileKey = "minion.reconcile"
+
+const (
+	reconcileInterval = 5 * time.Minute  // 默认的校对周期
+	reconcileMinimum  = 30 * time.Second // 校对周期的下限
+	reconcileBatch    = 500              // 按照节点 ID 查询时每批的节点数
+)
+
+// ReconcileConfig 数据库节点状态与内存会话的校对配置，由中心端写入 broker 运行时配置。
+type ReconcileConfig struct {
+	Interval int `json:"interval"` // 校对周期（秒），默认 300 秒，最小 30 秒
+}
+
+// reconciler 周期性的校对数据库中本 broker 的节点状态与内存中的会话。
+//
+// 节点上下线时修改数据库状态可能失败（数据库抖动、超时等），导致两边不一致且不会自愈。
+// 节点上下线过程中两边本来就会短暂不一致，所以同一处不一致连续两个周期都存在才会修正。
+type reconciler struct {
+	hub      *minionHub
+	offlines map[int64]struct{} // 上个周期发现的：数据库在线，但是没有会话
+	onlines  map[int64]*connect // 上个周期发现的：存在会话，但是数据库不是在线
+}
+
+// reconcile 周期性的校对节点状态，parent 结束后退出。
+func (hub *minionHub) reconcile(parent context.Context) {
+	rc := &reconciler{hub: hub}
+	for {
+		timer := time.NewTimer(hub.reconcileInterval(parent))
+		select {
+		case <-parent.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		ctx, cancel := context.WithTimeout(parent, time.Minute)
+		err := rc.run(ctx)
+		cancel()
+		if err != nil {
+			hub.log.Warn(fmt.Sprintf("校对节点状态出错：%v", err))
+		}
+	}
+}
+
+// reconcileInterval 读取校对周期。
+func (hub *minionHub) reconcileInterval(parent context.Context) time.Duration {
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	defer cancel()
+
+	var cfg ReconcileConfig
+	if err := hub.runtimeConfig(ctx, reconcileKey, &cfg); err != nil || cfg.Interval <= 0 {
+		return reconcileInterval
+	}
+
+	return max(time.Duration(cfg.Interval)*time.Second, reconcileMinimum)
+}
+
+func (rc *reconciler) run(ctx context.Context) error {
+	hub := rc.hub
+	online := uint8(model.MSOnline)
+	tbl := hub.qry.Minion
+
+	// 数据库中本 broker 在线的节点
+	var ids []int64
+	if err := tbl.WithContext(ctx).
+		Where(tbl.BrokerID.Eq(hub.bid), tbl.Status.Eq(online)).
+		Pluck(tbl.ID, &ids); err != nil {
+		return err
+	}
+	offlines := make(map[int64]struct{}, 8)
+	for _, id := range ids {
+		if hub.section.Get(strconv.FormatInt(id, 10)) == nil {
+			offlines[id] = struct{}{}
+		}
+	}
+
+	// 内存中的会话在数据库中的状态
+	conns := hub.section.Conns()
+	onlines := make(map[int64]*connect, 8)
+	for i := 0; i < len(conns); i += reconcileBatch {
+		batch := conns[i:min(i+reconcileBatch, len(conns))]
+		mids := make([]int64, 0, len(batch))
+		for _, c := range batch {
+			mids = append(mids, c.id)
+		}
+		mons, err := tbl.WithContext(ctx).
+			Select(tbl.ID, tbl.Status, tbl.BrokerID).
+			Where(tbl.ID.In(mids...)).
+			Find()
+		if err != nil {
+			return err
+		}
+		index := make(map[int64]*model.Minion, len(mons))
+		for _, mon := range mons {
+			index[mon.ID] = mon
+		}
+		for _, c := range batch {
+			mon := index[c.id]
+			if mon == nil || mon.Status != model.MSOnline || mon.BrokerID != hub.bid {
+				onlines[c.id] = c
+			}
+		}
+	}
+
+	// 连续两个周期都不一致的才修正
+	for id := range offlines {
+		if _, ok := rc.offlines[id]; ok {
+			rc.fixOffline(ctx, id)
+			delete(offlines, id)
+		}
+	}
+	for id, c := range onlines {
+		if rc.onlines[id] == c {
+			rc.fixOnline(ctx, c)
+			delete(onlines, id)
+		}
+	}
+	rc.offlines, rc.onlines = offlines, onlines
+
+	return nil
+}
+
+// fixOffline 数据库中在线但已经没有会话的节点，修改为离线。
+func (rc *reconciler) fixOffline(ctx context.Context, id int64) {
+	hub := rc.hub
+	online, offline := uint8(model.MSOnline), uint8(model.MSOffline)
+	tbl := hub.qry.Minion
+	ret, err := tbl.WithContext(ctx).
+		Where(tbl.ID.Eq(id), tbl.BrokerID.Eq(hub.bid), tbl.Status.Eq(online)).
+		UpdateSimple(tbl.Status.Value(offline))
+	if err != nil {
+		hub.log.Warn(fmt.Sprintf("校对节点 %d 的离线状态出错：%v", id, err))
+		return
+	}
+	if ret.RowsAffected == 0 {
+		return
+	}
+
+	reason := "数据库中节点为在线状态，但是 broker 上已经没有该节点的连接，已修改为离线"
+	hub.log.Warn(fmt.Sprintf("节点 %d 状态不一致：%s", id, reason))
+	hub.phase.Reconciled(id, "", reason, time.Now())
+}
+
+// fixOnline 存在会话但数据库中不是本 broker 在线的节点：
+// 节点已删除或不存在时断开连接，节点已被其它 broker 接管时交由租约处理，离线状态修改为在线。
+// 其它状态（如 2.0 遗留的 inactive）是人为设置的，不能被校对覆盖，只记录日志。
+func (rc *reconciler) fixOnline(ctx context.Context, c *connect) {
+	hub := rc.hub
+	id, inet := c.id, c.Inet().String()
+	tbl := hub.qry.Minion
+	mon, err := tbl.WithContext(ctx).
+		Select(tbl.ID, tbl.Status, tbl.BrokerID).
+		Where(tbl.ID.Eq(id)).
+		First()
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		hub.log.Warn(fmt.Sprintf("校对节点 %s(%d) 的在线状态出错：%v", inet, id, err))
+		return
+	}
+
+	var reason string
+	switch {
+	case mon == nil || mon.Status == model.MSDelete:
+		reason = "节点已在数据库中删除，但是连接仍在，已断开连接"
+		_ = hub.Knockout(ctx, id, reason, 0)
+	case mon.Status == model.MSOnline && mon.BrokerID != hub.bid:
+		return // 节点被其它 broker 接管，续租时会根据防护令牌断开
+	case mon.Status == model.MSOnline:
+		return // 已经恢复一致
+	case mon.Status == model.MSOffline:
+		online, offline := uint8(model.MSOnline), uint8(model.MSOffline)
+		ret, exx := tbl.WithContext(ctx).
+			Where(tbl.ID.Eq(id), tbl.Status.Eq(offline)).
+			UpdateSimple(
+				tbl.Status.Value(online),
+				tbl.BrokerID.Value(hub.bid),
+				tbl.BrokerName.Value(hub.link.Issue().Name),
+			)
+		if exx != nil || ret.RowsAffected == 0 {
+			hub.log.Warn(fmt.Sprintf("校对节点 %s(%d) 的在线状态出错：%v", inet, id, exx))
+			return
+		}
+		reason = "broker 上存在该节点的连接，但是数据库中节点为离线状态，已修改为在线"
+	default:
+		hub.log.Warn(fmt.Sprintf("节点 %s(%d) 存在连接，但是数据库中的状态为 %d，不做修正", inet, id, mon.Status))
+		return
+	}
+
+	hub.log.Warn(fmt.Sprintf("节点 %s(%d) 状态不一致：%s", inet, id, reason))
+	hub.phase.Reconciled(id, inet, reason, time.Now())
+}
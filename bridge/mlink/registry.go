@@ -0,0 +1,259 @@
+package mlink
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Filter 在线节点的筛选条件：不同条件之间为且的关系，同一条件的多个值之间为或的关系，
+// 为空的条件代表不限制。
+type Filter struct {
+	IDs     []int64        `json:"ids"`
+	Goos    []string       `json:"goos"`
+	Arch    []string       `json:"arch"`
+	Semver  []string       `json:"semver"`
+	Tags    []string       `json:"tags"`
+	Subnets []netip.Prefix `json:"subnets"`
+}
+
+// 二级索引的维度
+const (
+	indexGoos   = "goos"
+	indexArch   = "arch"
+	indexSemver = "semver"
+	indexTag    = "tag"
+)
+
+// registry 带有二级索引的在线节点注册表，按照操作系统、架构、版本和标签筛选节点时不需要查询数据库。
+type registry struct {
+	container
+	mutex sync.RWMutex
+	index map[string]map[string]map[int64]*connect // 维度 -> 值 -> 节点
+	tags  map[int64][]string                       // 节点缓存的标签
+}
+
+func newRegistry(c container) *registry {
+	return &registry{
+		container: c,
+		index: map[string]map[string]map[int64]*connect{
+			indexGoos:   {},
+			indexArch:   {},
+			indexSemver: {},
+			indexTag:    {},
+		},
+		tags: make(map[int64][]string, 1024),
+	}
+}
+
+func (rg *registry) Put(id string, conn *connect) bool {
+	if !rg.container.Put(id, conn) {
+		return false
+	}
+
+	ident := conn.ident
+	rg.mutex.Lock()
+	defer rg.mutex.Unlock()
+	if rg.container.Get(id) == conn { // 防止加入索引前已经被 Knockout
+		rg.add(indexGoos, ident.Goos, conn)
+		rg.add(indexArch, ident.Arch, conn)
+		rg.add(indexSemver, ident.Semver, conn)
+	}
+
+	return true
+}
+
+func (rg *registry) Del(id string) *connect {
+	conn := rg.container.Del(id)
+	if conn == nil {
+		return nil
+	}
+
+	ident := conn.ident
+	rg.mutex.Lock()
+	rg.remove(indexGoos, ident.Goos, conn)
+	rg.remove(indexArch, ident.Arch, conn)
+	rg.remove(indexSemver, ident.Semver, conn)
+	for _, tag := range rg.tags[conn.id] {
+		rg.remove(indexTag, tag, conn)
+	}
+	delete(rg.tags, conn.id)
+	rg.mutex.Unlock()
+
+	return conn
+}
+
+// Retag 更新节点缓存的标签。
+func (rg *registry) Retag(id int64, tags []string) {
+	conn := rg.Get(strconv.FormatInt(id, 10))
+	if conn == nil {
+		return
+	}
+
+	rg.mutex.Lock()
+	defer rg.mutex.Unlock()
+
+	// 节点可能在查询标签期间已经断开
+	if rg.Get(strconv.FormatInt(id, 10)) != conn {
+		return
+	}
+	for _, tag := range rg.tags[id] {
+		rg.remove(indexTag, tag, conn)
+	}
+	tags = slices.Compact(slices.Sorted(slices.Values(tags)))
+	for _, tag := range tags {
+		rg.add(indexTag, tag, conn)
+	}
+	rg.tags[id] = tags
+}
+
+// Select 根据条件筛选在线节点。
+func (rg *registry) Select(f Filter) []*connect {
+	rg.mutex.RLock()
+	defer rg.mutex.RUnlock()
+
+	// 选择候选集最小的维度作为遍历的起点，再用其它条件逐个过滤。
+	var candidates map[int64]*connect
+	smallest := -1
+	for dim, vals := range map[string][]string{
+		indexGoos:   f.Goos,
+		indexArch:   f.Arch,
+		indexSemver: f.Semver,
+		indexTag:    f.Tags,
+	} {
+		if len(vals) == 0 {
+			continue
+		}
+		var size int
+		for _, val := range vals {
+			size += len(rg.index[dim][val])
+		}
+		if smallest < 0 || size < smallest {
+			smallest = size
+			candidates = make(map[int64]*connect, size)
+			for _, val := range vals {
+				for id, conn := range rg.index[dim][val] {
+					candidates[id] = conn
+				}
+			}
+		}
+	}
+
+	var conns []*connect
+	switch {
+	case len(f.IDs) != 0 && (candidates == nil || len(f.IDs) < len(candidates)):
+		for _, id := range f.IDs {
+			if conn := rg.Get(strconv.FormatInt(id, 10)); conn != nil {
+				conns = append(conns, conn)
+			}
+		}
+	case candidates != nil:
+		conns = make([]*connect, 0, len(candidates))
+		for _, conn := range candidates {
+			conns = append(conns, conn)
+		}
+	default:
+		conns = rg.Conns()
+	}
+
+	var ids map[int64]struct{}
+	if len(f.IDs) != 0 {
+		ids = make(map[int64]struct{}, len(f.IDs))
+		for _, id := range f.IDs {
+			ids[id] = struct{}{}
+		}
+	}
+	ret := make([]*connect, 0, len(conns))
+	for _, conn := range conns {
+		if rg.match(conn, ids, f) {
+			ret = append(ret, conn)
+		}
+	}
+
+	return ret
+}
+
+func (rg *registry) match(conn *connect, ids map[int64]struct{}, f Filter) bool {
+	ident := conn.ident
+	if _, ok := ids[conn.id]; ids != nil && !ok ||
+		len(f.Goos) != 0 && !slices.Contains(f.Goos, ident.Goos) ||
+		len(f.Arch) != 0 && !slices.Contains(f.Arch, ident.Arch) ||
+		len(f.Semver) != 0 && !slices.Contains(f.Semver, ident.Semver) {
+		return false
+	}
+	if len(f.Tags) != 0 {
+		tags := rg.tags[conn.id]
+		if !slices.ContainsFunc(f.Tags, func(tag string) bool {
+			_, found := slices.BinarySearch(tags, tag)
+			return found
+		}) {
+			return false
+		}
+	}
+	if len(f.Subnets) != 0 {
+		var addrs []netip.Addr
+		inet, inet6 := ident.Inets()
+		for _, s := range []string{inet, inet6} {
+			if addr, err := netip.ParseAddr(s); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		if !slices.ContainsFunc(f.Subnets, func(pfx netip.Prefix) bool {
+			return slices.ContainsFunc(addrs, pfx.Contains)
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rg *registry) add(dim, val string, conn *connect) {
+	vals := rg.index[dim]
+	set := vals[val]
+	if set == nil {
+		set = make(map[int64]*connect, 16)
+		vals[val] = set
+	}
+	set[conn.id] = conn
+}
+
+func (rg *registry) remove(dim, val string, conn *connect) {
+	vals := rg.index[dim]
+	set := vals[val]
+	if set[conn.id] != conn {
+		return
+	}
+	delete(set, conn.id)
+	if len(set) == 0 {
+		delete(vals, val)
+	}
+}
+
+// Select 根据条件筛选在线节点的 ID，不需要查询数据库。
+func (hub *minionHub) Select(f Filter) []int64 {
+	conns := hub.section.Select(f)
+	ret := make([]int64, 0, len(conns))
+	for _, conn := range conns {
+		ret = append(ret, conn.id)
+	}
+
+	return ret
+}
+
+// Retag 从数据库重新加载节点的标签到注册表中，节点标签变更后调用。
+func (hub *minionHub) Retag(ctx context.Context, mid int64) error {
+	var tags []string
+	tbl := hub.qry.MinionTag
+	if err := tbl.WithContext(ctx).
+		Distinct(tbl.Tag).
+		Where(tbl.MinionID.Eq(mid)).
+		Scan(&tags); err != nil {
+		return err
+	}
+	hub.section.Retag(mid, tags)
+
+	return nil
+}
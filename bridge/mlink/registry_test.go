@@ -0,0 +1,116 @@
+package mlink
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+)
+
+// testRegistry 构造带有 5 个在线节点的注册表。
+func testRegistry() *registry {
+	rg := newRegistry(newSegmentMap(4, 16))
+	nodes := []struct {
+		id     int64
+		inet   string
+		inet6  string
+		goos   string
+		arch   string
+		semver string
+		tags   []string
+	}{
+		{1, "10.0.0.1", "", "linux", "amd64", "1.0.0", []string{"web", "prod"}},
+		{2, "10.0.0.2", "", "linux", "arm64", "1.0.0", []string{"db", "prod"}},
+		{3, "10.1.0.3", "", "windows", "amd64", "1.1.0", []string{"web"}},
+		{4, "192.168.1.4", "2001:db8::4", "linux", "amd64", "1.1.0", nil},
+		{5, "2001:db8::5", "", "darwin", "arm64", "1.0.0", []string{"dev"}},
+	}
+	for _, n := range nodes {
+		ident := gateway.Ident{Inet: net.ParseIP(n.inet), Goos: n.goos, Arch: n.arch, Semver: n.semver}
+		if n.inet6 != "" {
+			ident.Inet6 = net.ParseIP(n.inet6)
+		}
+		rg.Put(strconv.FormatInt(n.id, 10), &connect{id: n.id, ident: ident})
+		rg.Retag(n.id, n.tags)
+	}
+
+	return rg
+}
+
+func selectIDs(rg *registry, f Filter) []int64 {
+	ret := []int64{}
+	for _, conn := range rg.Select(f) {
+		ret = append(ret, conn.id)
+	}
+	slices.Sort(ret)
+
+	return ret
+}
+
+func TestRegistrySelect(t *testing.T) {
+	rg := testRegistry()
+	prefixes := func(ss ...string) []netip.Prefix {
+		ret := make([]netip.Prefix, 0, len(ss))
+		for _, s := range ss {
+			ret = append(ret, netip.MustParsePrefix(s))
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []int64
+	}{
+		{"没有条件", Filter{}, []int64{1, 2, 3, 4, 5}},
+		{"节点 ID", Filter{IDs: []int64{2, 4, 9}}, []int64{2, 4}},
+		{"操作系统", Filter{Goos: []string{"linux"}}, []int64{1, 2, 4}},
+		{"同一条件多个值为或", Filter{Goos: []string{"windows", "darwin"}}, []int64{3, 5}},
+		{"不同条件之间为且", Filter{Goos: []string{"linux"}, Arch: []string{"amd64"}, Semver: []string{"1.0.0"}}, []int64{1}},
+		{"标签", Filter{Tags: []string{"prod", "dev"}}, []int64{1, 2, 5}},
+		{"标签与架构", Filter{Tags: []string{"web"}, Arch: []string{"amd64"}}, []int64{1, 3}},
+		{"ID 与其它条件", Filter{IDs: []int64{1, 2, 3}, Arch: []string{"arm64"}}, []int64{2}},
+		{"ID 比候选集多", Filter{IDs: []int64{1, 2, 3, 4, 5}, Tags: []string{"db"}}, []int64{2}},
+		{"IPv4 网段", Filter{Subnets: prefixes("10.0.0.0/16")}, []int64{1, 2}},
+		{"IPv6 网段匹配 inet6", Filter{Subnets: prefixes("2001:db8::/32")}, []int64{4, 5}},
+		{"多个网段", Filter{Subnets: prefixes("10.1.0.0/16", "192.168.0.0/16"), Goos: []string{"linux"}}, []int64{4}},
+		{"不存在的值", Filter{Semver: []string{"9.9.9"}}, []int64{}},
+		{"不存在的标签", Filter{Tags: []string{"none"}}, []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectIDs(rg, tt.filter); !slices.Equal(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryUpdate(t *testing.T) {
+	rg := testRegistry()
+	rg.Del("1")
+	rg.Retag(2, []string{"web", "web"})
+	rg.Retag(9, []string{"web"}) // 不在线的节点
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []int64
+	}{
+		{"断开的节点不再被选中", Filter{Goos: []string{"linux"}}, []int64{2, 4}},
+		{"重新打标签", Filter{Tags: []string{"web"}}, []int64{2, 3}},
+		{"旧标签被移除", Filter{Tags: []string{"prod", "db"}}, []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectIDs(rg, tt.filter); !slices.Equal(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package mlink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/vela-ssoc/ssoc-broker/bridge/gateway"
+)
+
+// ReadCapture 读取抓包文件（NDJSON）中的所有记录。
+func ReadCapture(r io.Reader) ([]*CaptureEntry, error) {
+	var ents []*CaptureEntry
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		ent := new(CaptureEntry)
+		if err := dec.Decode(ent); err != nil {
+			if err == io.EOF {
+				return ents, nil
+			}
+			return ents, err
+		}
+		ents = append(ents, ent)
+	}
+}
+
+// ReplayResult 单个请求的重放结果。
+type ReplayResult struct {
+	Entry  *CaptureEntry // 抓包中的记录
+	Status int           // 重放后的响应状态码
+	Header http.Header   // 重放后的响应 Header
+	Body   []byte        // 重放后的响应 Body
+}
+
+// Matched 重放后的状态码是否与抓包时一致。
+func (rr *ReplayResult) Matched() bool {
+	return rr.Entry.Response != nil && rr.Entry.Response.Status == rr.Status
+}
+
+// Replay 将抓包中节点发起的请求（inbound）按照顺序重放到 handler，
+// handler 一般为测试 hub 的 LinkHub 参数，handler 中通过 Ctx 获取到的节点信息来自抓包记录。
+// 脱敏过的字段以脱敏后的值重放，被截断的 Body 以截断后的内容重放，未记录内容的（压缩或二进制）Body 以空 Body 重放。
+func Replay(ctx context.Context, ents []*CaptureEntry, h http.Handler) ([]*ReplayResult, error) {
+	ret := make([]*ReplayResult, 0, len(ents))
+	for _, ent := range ents {
+		if ent.Direction != CaptureInbound {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return ret, err
+		}
+
+		infer := &replayInfer{
+			ident: gateway.Ident{Inet: net.ParseIP(ent.Inet)},
+			issue: gateway.Issue{ID: ent.MinionID},
+		}
+		rctx := context.WithValue(ctx, minionCtxKey, infer)
+		msg := ent.Request
+		req := httptest.NewRequestWithContext(rctx, msg.Method, msg.URL, bytes.NewReader(msg.RawBody()))
+		req.Header = msg.Header.Clone()
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		req.Host = strconv.FormatInt(ent.MinionID, 10)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		res := rec.Result()
+		ret = append(ret, &ReplayResult{
+			Entry:  ent,
+			Status: res.StatusCode,
+			Header: res.Header,
+			Body:   rec.Body.Bytes(),
+		})
+	}
+
+	return ret, nil
+}
+
+// ReplayAgent 根据抓包中 broker 发往节点的请求（outbound）模拟节点：
+// 按照 method 和 URL 匹配，依次返回抓包时节点的响应，匹配不到时响应 404。
+// 用作测试环境中节点一侧的 handler，可以复现 broker 下发请求时节点的行为。
+func ReplayAgent(ents []*CaptureEntry) http.Handler {
+	ra := &replayAgent{responses: make(map[string][]*CaptureMessage, len(ents))}
+	for _, ent := range ents {
+		if ent.Direction != CaptureOutbound || ent.Response == nil {
+			continue
+		}
+		key := ent.Request.Method + " " + ent.Request.URL
+		ra.responses[key] = append(ra.responses[key], ent.Response)
+	}
+
+	return ra
+}
+
+type replayAgent struct {
+	mutex     sync.Mutex
+	responses map[string][]*CaptureMessage
+}
+
+func (ra *replayAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Method + " " + r.URL.RequestURI()
+	ra.mutex.Lock()
+	var msg *CaptureMessage
+	if queue := ra.responses[key]; len(queue) != 0 {
+		msg = queue[0]
+		if len(queue) > 1 { // 最后一个响应保留，后续相同的请求都返回它
+			ra.responses[key] = queue[1:]
+		}
+	}
+	ra.mutex.Unlock()
+
+	if msg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	for k, vs := range msg.Header {
+		w.Header()[k] = vs
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(msg.Status)
+	_, _ = w.Write(msg.RawBody())
+}
+
+type replayInfer struct {
+	ident gateway.Ident
+	issue gateway.Issue
+}
+
+func (ri *replayInfer) Ident() gateway.Ident { return ri.ident }
+func (ri *replayInfer) Issue() gateway.Issue { return ri.issue }
+func (ri *replayInfer) Inet() net.IP         { return ri.ident.Inet }
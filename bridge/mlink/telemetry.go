@@ -0,0 +1,203 @@
+package mlink
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStat 节点连接的统计信息。
+type ConnStat struct {
+	ID            int64     `json:"id"`
+	Inet          string    `json:"inet"`
+	Goos          string    `json:"goos"`
+	Arch          string    `json:"arch"`
+	Semver        string    `json:"semver"`
+	Hostname      string    `json:"hostname"`
+	JoinedAt      time.Time `json:"joined_at"`       // 连接建立时间
+	Streams       int64     `json:"streams"`         // 当前打开的 stream 数
+	StreamsTotal  int64     `json:"streams_total"`   // 累计打开的 stream 数
+	BytesIn       int64     `json:"bytes_in"`        // 累计从节点收到的字节数
+	BytesOut      int64     `json:"bytes_out"`       // 累计向节点发送的字节数
+	Requests      int64     `json:"requests"`        // 节点累计发起的请求数
+	Failures      int64     `json:"failures"`        // 节点请求中服务端出错（5xx）的次数
+	ErrorRate     float64   `json:"error_rate"`      // 请求错误率
+	LastRequestAt time.Time `json:"last_request_at"` // 节点最近一次发起请求的时间
+	Calls         int64     `json:"calls"`           // broker 累计向节点发起的请求数（包括 ARR 转发）
+	CallFailures  int64     `json:"call_failures"`   // broker 发往节点的请求中出错（网络错误、超时或 5xx）的次数
+	CallErrorRate float64   `json:"call_error_rate"` // broker 发往节点的请求错误率
+	LastCallAt    time.Time `json:"last_call_at"`    // broker 最近一次向节点发起请求的时间
+}
+
+// telemetry 单个节点连接的运行统计，所有字段都是原子操作，不影响转发性能。
+type telemetry struct {
+	joinedAt      time.Time
+	streams       atomic.Int64
+	streamsTotal  atomic.Int64
+	bytesIn       atomic.Int64
+	bytesOut      atomic.Int64
+	requests      atomic.Int64
+	failures      atomic.Int64
+	lastRequestAt atomic.Int64 // UnixNano
+	calls         atomic.Int64
+	callFailures  atomic.Int64
+	lastCallAt    atomic.Int64 // UnixNano
+}
+
+func (c *connect) stat() ConnStat {
+	tm := &c.telemetry
+	ident := c.ident
+	ret := ConnStat{
+		ID:           c.id,
+		Inet:         ident.Inet.String(),
+		Goos:         ident.Goos,
+		Arch:         ident.Arch,
+		Semver:       ident.Semver,
+		Hostname:     ident.Hostname,
+		JoinedAt:     tm.joinedAt,
+		Streams:      tm.streams.Load(),
+		StreamsTotal: tm.streamsTotal.Load(),
+		BytesIn:      tm.bytesIn.Load(),
+		BytesOut:     tm.bytesOut.Load(),
+		Requests:     tm.requests.Load(),
+		Failures:     tm.failures.Load(),
+		Calls:        tm.calls.Load(),
+		CallFailures: tm.callFailures.Load(),
+	}
+	if ret.Requests > 0 {
+		ret.ErrorRate = float64(ret.Failures) / float64(ret.Requests)
+	}
+	if ret.Calls > 0 {
+		ret.CallErrorRate = float64(ret.CallFailures) / float64(ret.Calls)
+	}
+	if at := tm.lastRequestAt.Load(); at > 0 {
+		ret.LastRequestAt = time.Unix(0, at)
+	}
+	if at := tm.lastCallAt.Load(); at > 0 {
+		ret.LastCallAt = time.Unix(0, at)
+	}
+
+	return ret
+}
+
+// wrapStream 包装 stream 统计流量。
+func (tm *telemetry) wrapStream(conn net.Conn) net.Conn {
+	tm.streams.Add(1)
+	tm.streamsTotal.Add(1)
+	return &statConn{Conn: conn, tm: tm}
+}
+
+// wrapHandler 包装 handler 统计节点发起的请求。
+func (tm *telemetry) wrapHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tm.requests.Add(1)
+		tm.lastRequestAt.Store(time.Now().UnixNano())
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		if sw.code >= http.StatusInternalServerError {
+			tm.failures.Add(1)
+		}
+	})
+}
+
+// telemetryTransport 包装 broker 发往节点的 Transport（hub client 与 ARR 转发共用），
+// 统计每个节点的请求数和失败次数，ARR 重试时每次尝试都会单独计数。
+func (hub *minionHub) telemetryTransport(next http.RoundTripper) http.RoundTripper {
+	return &statTransport{hub: hub, next: next}
+}
+
+type statTransport struct {
+	hub  *minionHub
+	next http.RoundTripper
+}
+
+func (st *statTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn := st.hub.section.Get(req.URL.Hostname())
+	if conn == nil { // 节点不在线，交给 dialContext 返回 ErrMinionOffline
+		return st.next.RoundTrip(req)
+	}
+
+	tm := &conn.telemetry
+	tm.calls.Add(1)
+	tm.lastCallAt.Store(time.Now().UnixNano())
+	res, err := st.next.RoundTrip(req)
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		tm.callFailures.Add(1)
+	}
+
+	return res, err
+}
+
+type statConn struct {
+	net.Conn
+	tm     *telemetry
+	closed atomic.Bool
+}
+
+func (sc *statConn) Read(b []byte) (int, error) {
+	n, err := sc.Conn.Read(b)
+	sc.tm.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (sc *statConn) Write(b []byte) (int, error) {
+	n, err := sc.Conn.Write(b)
+	sc.tm.bytesOut.Add(int64(n))
+	return n, err
+}
+
+func (sc *statConn) Close() error {
+	if sc.closed.CompareAndSwap(false, true) {
+		sc.tm.streams.Add(-1)
+	}
+	return sc.Conn.Close()
+}
+
+// statListener 统计节点主动打开的 stream。
+type statListener struct {
+	net.Listener
+	tm *telemetry
+}
+
+func (sl *statListener) Accept() (net.Conn, error) {
+	conn, err := sl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return sl.tm.wrapStream(conn), nil
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	wrote bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wrote {
+		sw.code, sw.wrote = code, true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wrote = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Hijack 节点的 websocket 等请求需要接管连接。
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	sw.wrote = true
+	return http.NewResponseController(sw.ResponseWriter).Hijack()
+}
+
+func (sw *statusWriter) Flush() {
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap 供 http.ResponseController 获取原始的 ResponseWriter（Hijack Flush 等）。
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,173 @@
+package telecom
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重连退避策略
+const (
+	BackoffExponential  = "exponential"  // 指数退避 + 全抖动（默认）
+	BackoffDecorrelated = "decorrelated" // 去相关抖动
+	BackoffFixed        = "fixed"        // 固定间隔
+)
+
+// Backoff 重连退避策略。
+//
+// 中心端重启时所有 broker 会同时断线，如果大家都按照相同的间隔重连，
+// 就会在同一时刻蜂拥而至，所以除 fixed 外的策略都带有随机抖动。
+type Backoff interface {
+	// Next 下一次重试前需要等待的时长。
+	Next() time.Duration
+
+	// Reset 连接成功后重置退避状态。
+	Reset()
+}
+
+// BackoffConfig 重连退避配置。
+type BackoffConfig struct {
+	// Policy 退避策略：exponential decorrelated fixed，默认 exponential。
+	Policy string `json:"policy" yaml:"policy"`
+
+	// Base 基础等待时长，默认 1s。
+	Base time.Duration `json:"base" yaml:"base"`
+
+	// Max 最长等待时长，默认 10min，中心端通过 Retry-After 要求的等待时长也不会超过该值。
+	Max time.Duration `json:"max" yaml:"max"`
+}
+
+func (bc BackoffConfig) base() time.Duration {
+	if du := bc.Base; du > 0 {
+		return du
+	}
+	return time.Second
+}
+
+func (bc BackoffConfig) maximum() time.Duration {
+	du := bc.Max
+	if du <= 0 {
+		du = 10 * time.Minute
+	}
+	return max(du, bc.base())
+}
+
+// NewBackoff 根据配置创建退避策略。
+func NewBackoff(cfg BackoffConfig) Backoff {
+	base, maximum := cfg.base(), cfg.maximum()
+
+	switch strings.ToLower(cfg.Policy) {
+	case BackoffFixed:
+		return &fixedBackoff{du: base}
+	case BackoffDecorrelated:
+		return &decorrelatedBackoff{base: base, max: maximum, prev: base}
+	default:
+		return &exponentialBackoff{base: base, max: maximum}
+	}
+}
+
+// exponentialBackoff 指数退避 + 全抖动：sleep = random(0, min(max, base * 2^attempt))
+//
+// https://aws.amazon.com/cn/blogs/architecture/exponential-backoff-and-jitter/
+type exponentialBackoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func (eb *exponentialBackoff) Next() time.Duration {
+	ceil := eb.max
+	if eb.attempt < 32 {
+		if du := eb.base << eb.attempt; du > 0 && du < ceil {
+			ceil = du
+		}
+		eb.attempt++
+	}
+
+	return jitter(0, ceil)
+}
+
+func (eb *exponentialBackoff) Reset() { eb.attempt = 0 }
+
+// decorrelatedBackoff 去相关抖动：sleep = min(max, random(base, prev * 3))
+type decorrelatedBackoff struct {
+	base time.Duration
+	max  time.Duration
+	prev time.Duration
+}
+
+func (db *decorrelatedBackoff) Next() time.Duration {
+	du := jitter(db.base, 3*db.prev)
+	if du > db.max {
+		du = db.max
+	}
+	db.prev = du
+
+	return du
+}
+
+func (db *decorrelatedBackoff) Reset() { db.prev = db.base }
+
+type fixedBackoff struct {
+	du time.Duration
+}
+
+func (fb *fixedBackoff) Next() time.Duration { return fb.du }
+func (fb *fixedBackoff) Reset()              {}
+
+// jitter 返回 [lo, hi] 区间内的随机时长。
+func jitter(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + rand.N(hi-lo+1)
+}
+
+// retryAfterSleep 中心端要求的等待时长不超过 maximum，并随机提前 10% 以内。
+func retryAfterSleep(after, maximum time.Duration) time.Duration {
+	after = min(after, maximum)
+	return after - jitter(0, after/10)
+}
+
+// retryAfterError 中心端在协商响应中携带了 Retry-After，要求 broker 延迟重试。
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// retryAfter 从错误中提取中心端要求的重试等待时长。
+func retryAfter(err error) (time.Duration, bool) {
+	var rae *retryAfterError
+	if errors.As(err, &rae) {
+		return rae.after, true
+	}
+	return 0, false
+}
+
+// parseRetryAfter 解析 Retry-After 响应头，支持秒数和 HTTP 日期两种格式。
+//
+// https://developer.mozilla.org/zh-CN/docs/Web/HTTP/Headers/Retry-After
+func parseRetryAfter(val string) time.Duration {
+	if val = strings.TrimSpace(val); val == "" {
+		return 0
+	}
+	if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+		if sec > 0 {
+			return time.Duration(sec) * time.Second
+		}
+		return 0
+	}
+	if at, err := http.ParseTime(val); err == nil {
+		if du := time.Until(at); du > 0 {
+			return du
+		}
+	}
+
+	return 0
+}
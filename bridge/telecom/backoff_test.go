@@ -0,0 +1,138 @@
+package telecom
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    BackoffConfig
+		rounds int
+		lo, hi []time.Duration // 每一轮 Next 的取值范围
+	}{
+		{
+			name:   "指数退避默认配置",
+			cfg:    BackoffConfig{},
+			rounds: 4,
+			lo:     []time.Duration{0, 0, 0, 0},
+			hi:     []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			name:   "指数退避不超过上限",
+			cfg:    BackoffConfig{Base: time.Second, Max: 3 * time.Second},
+			rounds: 4,
+			lo:     []time.Duration{0, 0, 0, 0},
+			hi:     []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:   "上限小于基础时长",
+			cfg:    BackoffConfig{Base: 5 * time.Second, Max: time.Second},
+			rounds: 2,
+			lo:     []time.Duration{0, 0},
+			hi:     []time.Duration{5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:   "去相关抖动",
+			cfg:    BackoffConfig{Policy: "Decorrelated", Base: time.Second, Max: 5 * time.Second},
+			rounds: 3,
+			lo:     []time.Duration{time.Second, time.Second, time.Second},
+			hi:     []time.Duration{3 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:   "固定间隔",
+			cfg:    BackoffConfig{Policy: BackoffFixed, Base: 2 * time.Second},
+			rounds: 3,
+			lo:     []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second},
+			hi:     []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 { // 带随机抖动，多跑几次
+				bo := NewBackoff(tt.cfg)
+				for i := range tt.rounds {
+					if du := bo.Next(); du < tt.lo[i] || du > tt.hi[i] {
+						t.Fatalf("第 %d 次 Next() = %s, want [%s, %s]", i+1, du, tt.lo[i], tt.hi[i])
+					}
+				}
+				bo.Reset()
+				if du := bo.Next(); du < tt.lo[0] || du > tt.hi[0] {
+					t.Fatalf("Reset 后 Next() = %s, want [%s, %s]", du, tt.lo[0], tt.hi[0])
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffConfigMaximum(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  BackoffConfig
+		want time.Duration
+	}{
+		{"默认", BackoffConfig{}, 10 * time.Minute},
+		{"自定义", BackoffConfig{Max: time.Minute}, time.Minute},
+		{"不小于基础时长", BackoffConfig{Base: time.Hour, Max: time.Minute}, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.maximum(); got != tt.want {
+				t.Errorf("maximum() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		val    string
+		lo, hi time.Duration
+	}{
+		{"空值", "", 0, 0},
+		{"秒数", "120", 2 * time.Minute, 2 * time.Minute},
+		{"带空格的秒数", " 5 ", 5 * time.Second, 5 * time.Second},
+		{"零", "0", 0, 0},
+		{"负数", "-3", 0, 0},
+		{"非法值", "soon", 0, 0},
+		{"HTTP 日期", now.Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{"过去的 HTTP 日期", now.Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.val); got < tt.lo || got > tt.hi {
+				t.Errorf("parseRetryAfter(%q) = %s, want [%s, %s]", tt.val, got, tt.lo, tt.hi)
+			}
+		})
+	}
+}
+
+func TestRetryAfterSleep(t *testing.T) {
+	tests := []struct {
+		name    string
+		after   time.Duration
+		maximum time.Duration
+		lo, hi  time.Duration
+	}{
+		{"未超过上限", 30 * time.Second, 10 * time.Minute, 27 * time.Second, 30 * time.Second},
+		{"超过上限", time.Hour, 10 * time.Minute, 9 * time.Minute, 10 * time.Minute},
+		{"不足 10 个纳秒时没有抖动", 5, time.Minute, 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				if got := retryAfterSleep(tt.after, tt.maximum); got < tt.lo || got > tt.hi {
+					t.Fatalf("retryAfterSleep(%s, %s) = %s, want [%s, %s]", tt.after, tt.maximum, got, tt.lo, tt.hi)
+				}
+			}
+		})
+	}
+}
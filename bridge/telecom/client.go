@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -13,7 +12,12 @@ import (
 	"net/http"
 	"os"
 	"os/user"
+	"reflect"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vela-ssoc/ssoc-common-mb/param/negotiate"
@@ -23,33 +27,56 @@ import (
 )
 
 type brokerClient struct {
-	hide   negotiate.Hide
-	ident  negotiate.Ident
-	issue  negotiate.Issue
-	client netutil.HTTPClient
-	log    *slog.Logger
-	dialer *iterDial
-	mux    *smux.Session
-	joinAt time.Time
-	parent context.Context
-	ctx    context.Context
-	cancel context.CancelFunc
+	hide    Hide
+	ident   negotiate.Ident
+	issue   negotiate.Issue
+	client  netutil.HTTPClient
+	fetcher *http.Client
+	pinger  *http.Client
+	beat    heartbeatState
+	log     *slog.Logger
+	dialer  *iterDial
+	backoff Backoff
+	spool   *spool
+	hookMu  sync.Mutex
+	hooks   []func(old, cur negotiate.Issue)
+	mux     atomic.Pointer[smux.Session] // 心跳、转发等后台协程会并发读取，重连时替换
+	mutex   sync.RWMutex                 // 保护 ident issue joinAt，重连时会被替换
+	joinAt  time.Time
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
 func (bc *brokerClient) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	return bc.dialContext(ctx, network, addr)
 }
-func (bc *brokerClient) Hide() negotiate.Hide   { return bc.hide }
-func (bc *brokerClient) Ident() negotiate.Ident { return bc.ident }
-func (bc *brokerClient) Issue() negotiate.Issue { return bc.issue }
-func (bc *brokerClient) Listen() net.Listener   { return bc.mux }
+func (bc *brokerClient) Hide() Hide               { return bc.hide }
+func (bc *brokerClient) Listen() net.Listener     { return bc.mux.Load() }
+func (bc *brokerClient) Heartbeat() HeartbeatStat { return bc.beat.Stat() }
+func (bc *brokerClient) Addresses() []AddressStat { return bc.dialer.stats() }
+func (bc *brokerClient) Spool() SpoolStat         { return bc.spool.Stat() }
+
+func (bc *brokerClient) Ident() negotiate.Ident {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.ident
+}
+
+func (bc *brokerClient) Issue() negotiate.Issue {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.issue
+}
 
 func (bc *brokerClient) JoinAt() time.Time {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
 	return bc.joinAt
 }
 
 func (bc *brokerClient) Name() string {
-	return fmt.Sprintf("broker-%s-%d", bc.ident.Inet, bc.ident.ID)
+	ident := bc.Ident()
+	return fmt.Sprintf("broker-%s-%d", ident.Inet, ident.ID)
 }
 
 func (bc *brokerClient) Reconnect(parent context.Context) error {
@@ -57,15 +84,34 @@ func (bc *brokerClient) Reconnect(parent context.Context) error {
 	return bc.dial(parent)
 }
 
+func (bc *brokerClient) OnIssue(fn func(old, cur negotiate.Issue)) {
+	if fn == nil {
+		return
+	}
+	bc.hookMu.Lock()
+	bc.hooks = append(bc.hooks, fn)
+	bc.hookMu.Unlock()
+}
+
+// issueChanged 重连后中心端下发的配置发生了变化，依次通知订阅方。
+func (bc *brokerClient) issueChanged(old, cur negotiate.Issue) {
+	bc.hookMu.Lock()
+	hooks := slices.Clone(bc.hooks)
+	bc.hookMu.Unlock()
+
+	bc.log.Info("中心端下发的配置发生变化，通知订阅方更新", slog.Int("hooks", len(hooks)))
+	for _, fn := range hooks {
+		fn(old, cur)
+	}
+}
+
 func (bc *brokerClient) close() error {
 	bc.cancel()
-	return bc.mux.Close()
+	return bc.mux.Load().Close()
 }
 
 func (bc *brokerClient) dial(parent context.Context) error {
-	bc.parent = parent
 	bc.ctx, bc.cancel = context.WithCancel(parent)
-	start := time.Now()
 
 	for {
 		conn, addr, err := bc.dialer.iterDial(bc.ctx, 5*time.Second)
@@ -74,18 +120,34 @@ func (bc *brokerClient) dial(parent context.Context) error {
 				return ce
 			}
 			bc.log.Warn("连接失败", slog.Any("addr", addr), slog.Any("error", err))
-			bc.dialSleep(bc.ctx, start)
+			bc.dialSleep(bc.ctx, nil)
 			continue
 		}
 
 		bc.log.Info("连接成功，准备握手协商...", slog.Any("addr", addr))
-		ident, issue, err := bc.consult(bc.ctx, conn, addr)
+		ident, issue, encrypt, err := bc.consult(bc.ctx, conn, addr)
 		if err == nil {
 			cfg := smux.DefaultConfig()
-			cfg.KeepAliveDisabled = true
+			cfg.KeepAliveDisabled = true // 链路探活由应用层心跳负责
+			if encrypt {
+				// 非 TLS 传输且中心端支持时，使用协商得到的密钥对整个会话加密，与 minion 节点的 smux 会话一致。
+				cfg.Passwd = issue.Passwd
+			} else if !addr.TLS {
+				bc.log.Warn("中心端不支持会话加密，隧道将以明文传输", slog.Any("addr", addr))
+			}
 			mux := smux.Client(conn, cfg)
-			// mux := spdy.Client(conn, spdy.WithEncrypt(issue.Passwd))
-			bc.ident, bc.issue, bc.mux, bc.joinAt = ident, issue, mux, time.Now()
+			now := time.Now()
+			bc.mutex.Lock()
+			old, rejoin := bc.issue, !bc.joinAt.IsZero()
+			bc.ident, bc.issue, bc.joinAt = ident, issue, now
+			bc.mutex.Unlock()
+			bc.mux.Store(mux)
+			bc.beat.reset(now)
+			bc.backoff.Reset()
+			bc.spool.wake()
+			if rejoin && !reflect.DeepEqual(old, issue) {
+				bc.issueChanged(old, issue)
+			}
 			return nil
 		}
 
@@ -94,25 +156,29 @@ func (bc *brokerClient) dial(parent context.Context) error {
 			return pe
 		}
 
-		if he, ok := err.(*netutil.HTTPError); ok && he.NotAcceptable() {
+		var he *netutil.HTTPError
+		var pde problem.Detail
+		var pe *problem.Detail
+		if errors.As(err, &he) && he.NotAcceptable() {
 			return he
-		} else if pde, ok := err.(problem.Detail); ok && pde.Status == http.StatusNotAcceptable {
+		} else if errors.As(err, &pde) && pde.Status == http.StatusNotAcceptable {
 			return pde
-		} else if pe, ok := err.(*problem.Detail); ok && pe.Status == http.StatusNotAcceptable {
+		} else if errors.As(err, &pe) && pe.Status == http.StatusNotAcceptable {
 			return pe
 		}
 
 		// bc.log.Warn("握手数据协商失败", slog.Any("addr", addr), slog.Any("error", err))
-		bc.dialSleep(parent, start)
+		bc.dialer.handshakeFailed(addr, err)
+		bc.dialSleep(parent, err)
 	}
 }
 
-// consult 当建立好 TCP 连接后进行应用层协商
-func (bc *brokerClient) consult(parent context.Context, conn net.Conn, addr *netutil.Address) (negotiate.Ident, negotiate.Issue, error) {
+// consult 当建立好 TCP 连接后进行应用层协商，encrypt 表示双方是否约定了 smux 会话加密。
+func (bc *brokerClient) consult(parent context.Context, conn net.Conn, addr *Address) (ident negotiate.Ident, issue negotiate.Issue, encrypt bool, err error) {
 	ip := conn.LocalAddr().(*net.TCPAddr).IP
 	mac := bc.dialer.lookupMAC(ip)
 
-	ident := negotiate.Ident{
+	ident = negotiate.Ident{
 		ID:     bc.hide.ID,
 		Secret: bc.hide.Secret,
 		Semver: bc.hide.Semver,
@@ -132,44 +198,40 @@ func (bc *brokerClient) consult(parent context.Context, conn net.Conn, addr *net
 		ident.Username = cu.Username
 	}
 
-	var issue negotiate.Issue
 	enc, err := ident.Encrypt()
 	if err != nil {
-		return ident, issue, err
+		return ident, issue, false, err
 	}
 	buf := bytes.NewReader(enc)
 
 	const endpoint = "http://vtun/api/v1/broker"
 	req, err := bc.client.NewRequest(parent, http.MethodConnect, endpoint, buf, nil)
 	if err != nil {
-		return ident, issue, err
+		return ident, issue, false, err
+	}
+	if !addr.TLS {
+		// 旧版本的中心端不认识该请求头，响应中也不会带回，此时保持原来的明文 smux 会话。
+		req.Header.Set(capabilityHeader, capabilityEncrypt)
 	}
 
 	host := addr.Name
 	req.Host = host
 	req.URL.Host = host
 	if err = req.Write(conn); err != nil {
-		return ident, issue, err
+		return ident, issue, false, err
 	}
 
 	res, err := http.ReadResponse(bufio.NewReader(conn), req)
 	if err != nil {
-		return ident, issue, err
+		return ident, issue, false, err
 	}
 	//goland:noinspection GoUnhandledErrorResult
 	defer res.Body.Close()
 
-	code := res.StatusCode
-	if code != http.StatusAccepted {
-		resp := make([]byte, 10*1024)
-		n, _ := io.ReadFull(res.Body, resp)
-		pd := new(problem.Detail)
-		if err = json.Unmarshal(resp[:n], pd); err == nil {
-			return ident, issue, pd
-		}
-		exr := &netutil.HTTPError{Code: code, Body: resp[:n]}
-		return ident, issue, exr
+	if res.StatusCode != http.StatusAccepted {
+		return ident, issue, false, responseError(res)
 	}
+	encrypt = !addr.TLS && hasCapability(res.Header, capabilityEncrypt)
 
 	resp := make([]byte, 100*1024) // 100KiB 缓冲区
 	n, err := io.ReadFull(res.Body, resp)
@@ -177,24 +239,33 @@ func (bc *brokerClient) consult(parent context.Context, conn net.Conn, addr *net
 		err = issue.Decrypt(resp[:n])
 	}
 
-	return ident, issue, err
+	return ident, issue, encrypt, err
 }
 
-func (bc *brokerClient) dialSleep(ctx context.Context, start time.Time) {
-	since := time.Since(start)
-	du := time.Second
+// 握手时协商的能力：broker 在请求头中声明支持的能力，中心端在响应头中返回双方都支持的能力。
+const (
+	capabilityHeader  = "X-Tunnel-Capability"
+	capabilityEncrypt = "smux-encrypt" // smux 会话使用 Issue.Passwd 加密
+)
 
-	switch {
-	case since > 12*time.Hour:
-		du = 10 * time.Minute
-	case since > time.Hour:
-		du = time.Minute
-	case since > 30*time.Minute:
-		du = 30 * time.Second
-	case since > 10*time.Minute:
-		du = 10 * time.Second
-	case since > 3*time.Minute:
-		du = 3 * time.Second
+func hasCapability(header http.Header, capability string) bool {
+	for _, val := range header.Values(capabilityHeader) {
+		for _, c := range strings.Split(val, ",") {
+			if strings.EqualFold(strings.TrimSpace(c), capability) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialSleep 连接或协商失败后按照退避策略休眠。
+// 如果中心端通过 Retry-After 指定了等待时长则以中心端为准（不超过退避配置的最长等待时长），
+// 并在此基础上随机提前 10% 以内，防止中心端给所有 broker 返回相同的 Retry-After 导致再次同时重连。
+func (bc *brokerClient) dialSleep(ctx context.Context, err error) {
+	du := bc.backoff.Next()
+	if after, ok := retryAfter(err); ok {
+		du = retryAfterSleep(after, bc.hide.Backoff.maximum())
 	}
 
 	// 非阻塞休眠
@@ -205,7 +276,7 @@ func (bc *brokerClient) dialSleep(ctx context.Context, start time.Time) {
 }
 
 func (bc *brokerClient) dialContext(_ context.Context, _, _ string) (net.Conn, error) {
-	mux := bc.mux
+	mux := bc.mux.Load()
 	if mux == nil {
 		return nil, io.ErrNoProgress
 	}
@@ -216,17 +287,3 @@ func (bc *brokerClient) dialContext(_ context.Context, _, _ string) (net.Conn, e
 		return stream, nil
 	}
 }
-
-func (bc *brokerClient) heartbeat(du time.Duration) {
-	ticker := time.NewTicker(du)
-	defer ticker.Stop()
-
-	var over bool
-	for !over {
-		select {
-		case <-ticker.C:
-		case <-bc.parent.Done():
-			over = true
-		}
-	}
-}
@@ -4,49 +4,172 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
+	"sync"
 	"time"
-
-	"github.com/vela-ssoc/vela-common-mba/netutil"
 )
 
-func newIterDial(addrs netutil.Addresses) *iterDial {
-	dialer := &tls.Dialer{NetDialer: new(net.Dialer)}
+func newIterDial(addrs Addresses, tlsCfg *tls.Config) *iterDial {
+	dialer := new(net.Dialer)
 	macs := make(map[string]net.HardwareAddr, 4)
-	length := len(addrs)
+	healths := make([]*addrHealth, 0, len(addrs))
+	for _, addr := range addrs {
+		healths = append(healths, &addrHealth{addr: addr})
+	}
 
 	return &iterDial{
-		dial:   dialer,
-		macs:   macs,
-		addrs:  addrs,
-		length: length,
+		dial:    dialer,
+		tlsCfg:  tlsCfg,
+		macs:    macs,
+		healths: healths,
 	}
 }
 
 type iterDial struct {
-	dial   *tls.Dialer
-	macs   map[string]net.HardwareAddr
-	addrs  netutil.Addresses
-	length int
-	index  int
+	dial    *net.Dialer
+	tlsCfg  *tls.Config // TLS 配置模板
+	macs    map[string]net.HardwareAddr
+	mutex   sync.Mutex
+	healths []*addrHealth
 }
 
-func (dl *iterDial) iterDial(parent context.Context, timeout time.Duration) (net.Conn, *netutil.Address, error) {
-	idx := dl.index
-	addr := dl.addrs[idx]
-	dl.index = (idx + 1) % dl.length
+func (dl *iterDial) iterDial(parent context.Context, timeout time.Duration) (net.Conn, *Address, error) {
+	addr, err := dl.pick()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
-	if addr.TLS {
-		dial := dl.dial
-		dial.Config = &tls.Config{ServerName: addr.Name}
-		conn, err := dial.DialContext(ctx, "tcp", addr.Addr)
-		return conn, addr, err
-	} else {
-		conn, err := dl.dial.NetDialer.DialContext(ctx, "tcp", addr.Addr)
-		return conn, addr, err
+	start := time.Now()
+	conn, err := dl.dialTCP(ctx, addr)
+	if err == nil && addr.TLS {
+		cfg := dl.tlsCfg.Clone()
+		cfg.ServerName = addr.Name
+		tc := tls.Client(conn, cfg)
+		if err = tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			conn = nil
+		} else {
+			conn = tc
+		}
+	}
+
+	// 上层 context 取消导致的失败不能算作地址的问题。
+	if err == nil {
+		dl.report(addr, time.Since(start), "", nil)
+	} else if parent.Err() == nil {
+		dl.report(addr, 0, classifyError(err), err)
+	}
+
+	return conn, addr, err
+}
+
+// dialTCP 建立 TCP 连接，配置了代理的地址通过代理建立隧道。
+func (dl *iterDial) dialTCP(ctx context.Context, addr *Address) (net.Conn, error) {
+	if addr.Proxy == "" {
+		return dl.dial.DialContext(ctx, "tcp", addr.Addr)
+	}
+
+	return dialProxy(ctx, dl.dial, addr.Proxy, addr.Addr)
+}
+
+// handshakeFailed 连接建立成功但应用层协商失败。
+func (dl *iterDial) handshakeFailed(addr *Address, err error) {
+	dl.report(addr, 0, errClassHandshake, err)
+}
+
+// pick 选择一个最佳地址：优先选择未被隔离且评分最高的地址，评分相同时选择最久未尝试的地址；
+// 如果所有地址都处于隔离期，则选择最早解除隔离的地址；没有任何地址时返回 ErrNoAvailableAddress。
+func (dl *iterDial) pick() (*Address, error) {
+	now := time.Now()
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+
+	var best, earliest *addrHealth
+	var bestScore float64
+	for _, h := range dl.healths {
+		if h.quarantined(now) {
+			if earliest == nil || h.quarantine.Before(earliest.quarantine) {
+				earliest = h
+			}
+			continue
+		}
+
+		score := h.score(now)
+		if best == nil || score > bestScore ||
+			(score == bestScore && h.triedAt.Before(best.triedAt)) {
+			best, bestScore = h, score
+		}
+	}
+	if best == nil {
+		best = earliest
 	}
+	if best == nil {
+		return nil, ErrNoAvailableAddress
+	}
+	best.triedAt = now
+
+	return best.addr, nil
+}
+
+func (dl *iterDial) report(addr *Address, du time.Duration, class string, err error) {
+	now := time.Now()
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+
+	for _, h := range dl.healths {
+		if h.addr != addr {
+			continue
+		}
+		if err == nil {
+			h.succeed(now, du)
+		} else {
+			h.fail(now, class, err)
+		}
+		break
+	}
+}
+
+// update 合并新的地址集合：仍然存在的地址保留原有的健康状态，
+// 新增的地址从零开始评分，不再存在的地址直接移除。
+func (dl *iterDial) update(addrs Addresses) (added, removed int) {
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+
+	olds := make(map[string]*addrHealth, len(dl.healths))
+	for _, h := range dl.healths {
+		olds[h.addr.key()] = h
+	}
+
+	healths := make([]*addrHealth, 0, len(addrs))
+	for _, addr := range addrs {
+		key := addr.key()
+		if h, ok := olds[key]; ok {
+			healths = append(healths, h)
+			delete(olds, key)
+		} else {
+			healths = append(healths, &addrHealth{addr: addr})
+			added++
+		}
+	}
+	dl.healths = healths
+
+	return added, len(olds)
+}
+
+// stats 所有地址当前的健康状态。
+func (dl *iterDial) stats() []AddressStat {
+	now := time.Now()
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+
+	ret := make([]AddressStat, 0, len(dl.healths))
+	for _, h := range dl.healths {
+		ret = append(ret, h.stat(now))
+	}
+
+	return ret
 }
 
 func (dl *iterDial) lookupMAC(ip net.IP) net.HardwareAddr {
@@ -0,0 +1,148 @@
+package telecom
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srvService 中心端地址的 SRV 服务名，完整的查询名称为 _ssoc-manager._tcp.<domain>
+const srvService = "ssoc-manager"
+
+// DiscoveryConfig 中心端地址动态发现配置。
+//
+// 发现的地址与 Servers 中静态配置的地址合并后参与健康评分，
+// 中心端迁移时只需要修改 DNS 记录，不需要重新下发 broker 二进制。
+type DiscoveryConfig struct {
+	// Domains 通过 DNS SRV 记录 _ssoc-manager._tcp.<domain> 发现中心端地址。
+	Domains []string `json:"domains" yaml:"domains"`
+
+	// TLS SRV 记录发现的地址是否开启了 TLS。
+	TLS bool `json:"tls" yaml:"tls"`
+
+	// Proxy SRV 记录发现的地址使用的出口代理，格式同 Address.Proxy。
+	Proxy string `json:"proxy" yaml:"proxy"`
+
+	// Expand 将解析出多条 A/AAAA 记录的域名展开为多个 IP 地址，每个 IP 单独评分，
+	// 展开后 Name 仍然是原域名，不影响 Host 和证书校验。配置了代理的地址由代理解析，不会展开。
+	Expand bool `json:"expand" yaml:"expand"`
+
+	// Interval 重新解析的间隔，默认 5min。
+	Interval time.Duration `json:"interval" yaml:"interval"`
+}
+
+func (dc DiscoveryConfig) enabled() bool {
+	return len(dc.Domains) != 0 || dc.Expand
+}
+
+func (dc DiscoveryConfig) interval() time.Duration {
+	if du := dc.Interval; du > 0 {
+		return du
+	}
+	return 5 * time.Minute
+}
+
+// discovery 中心端地址发现。
+type discovery struct {
+	cfg      DiscoveryConfig
+	servers  Addresses // 静态配置的地址（已格式化）
+	resolver *net.Resolver
+	log      *slog.Logger
+}
+
+// lookup 解析出当前全部的中心端地址。
+func (dv *discovery) lookup(parent context.Context) Addresses {
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	defer cancel()
+
+	cfg := dv.cfg
+	ret := make(Addresses, 0, len(dv.servers)+8)
+	ret = append(ret, dv.servers...)
+	for _, domain := range cfg.Domains {
+		_, srvs, err := dv.resolver.LookupSRV(ctx, srvService, "tcp", domain)
+		if err != nil {
+			dv.log.Warn("SRV 记录查询失败", slog.String("domain", domain), slog.Any("error", err))
+			continue
+		}
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			port := strconv.FormatUint(uint64(srv.Port), 10)
+			addr := &Address{TLS: cfg.TLS, Addr: net.JoinHostPort(host, port), Name: host, Proxy: cfg.Proxy}
+			ret = append(ret, addr)
+		}
+	}
+	if cfg.Expand {
+		ret = dv.expand(ctx, ret)
+	}
+
+	return dv.unique(ret)
+}
+
+// expand 将域名地址展开为 IP 地址，解析失败时保留原地址。
+func (dv *discovery) expand(ctx context.Context, addrs Addresses) Addresses {
+	ret := make(Addresses, 0, len(addrs))
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr.Addr)
+		if err != nil || addr.Proxy != "" || net.ParseIP(host) != nil {
+			ret = append(ret, addr)
+			continue
+		}
+
+		ips, err := dv.resolver.LookupIPAddr(ctx, host)
+		if err != nil || len(ips) == 0 {
+			dv.log.Warn("域名解析失败", slog.String("host", host), slog.Any("error", err))
+			ret = append(ret, addr)
+			continue
+		}
+		for _, ip := range ips {
+			ret = append(ret, &Address{
+				TLS:  addr.TLS,
+				Addr: net.JoinHostPort(ip.IP.String(), port),
+				Name: addr.Name,
+			})
+		}
+	}
+
+	return ret
+}
+
+func (dv *discovery) unique(addrs Addresses) Addresses {
+	hm := make(map[string]struct{}, len(addrs))
+	ret := make(Addresses, 0, len(addrs))
+	for _, addr := range addrs {
+		key := addr.key()
+		if _, exist := hm[key]; exist {
+			continue
+		}
+		hm[key] = struct{}{}
+		ret = append(ret, addr)
+	}
+
+	return ret
+}
+
+// discover 周期性的重新发现中心端地址，并合并到拨号器的地址集合中。
+func (bc *brokerClient) discover(parent context.Context, dv *discovery) {
+	ticker := time.NewTicker(dv.cfg.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-parent.Done():
+			return
+		case <-ticker.C:
+		}
+
+		addrs := dv.lookup(parent)
+		if len(addrs) == 0 { // 全部解析失败时保留之前的地址
+			continue
+		}
+		if added, removed := bc.dialer.update(addrs); added+removed != 0 {
+			bc.log.Info("中心端地址发生变化", slog.Int("added", added),
+				slog.Int("removed", removed), slog.Int("total", len(addrs)))
+		}
+	}
+}
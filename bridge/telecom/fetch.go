@@ -0,0 +1,206 @@
+package telecom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-common-mb/problem"
+	"github.com/vela-ssoc/vela-common-mba/netutil"
+)
+
+const (
+	fetchTimeout  = 30 * time.Second       // 调用方未设置 deadline 时的默认超时时间
+	fetchRetries  = 3                      // 幂等请求最多重试次数
+	fetchBackoff  = 200 * time.Millisecond // 重试的基础等待时长
+	errorBodySize = 10 * 1024              // 错误响应最多读取的字节数
+)
+
+// Fetch 通过隧道向中心端发送请求，path 为中心端的接口路径，如：/api/v1/broker/ping。
+//
+// 如果 ctx 没有设置 deadline 则默认 30s 超时。GET HEAD OPTIONS PUT DELETE 等幂等请求
+// 在隧道错误或中心端返回 502 503 504 时会自动重试。
+// 响应状态码非 2xx 时返回错误：中心端返回的是 problem.Detail 就解析为 *problem.Detail，
+// 否则返回 *netutil.HTTPError。请求成功时调用方负责关闭响应 Body。
+func (bc *brokerClient) Fetch(ctx context.Context, method, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	ctx, cancel := bc.fetchContext(ctx)
+	res, err := bc.fetch(ctx, method, path, body, header)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+
+	return res, nil
+}
+
+// Oneway 向中心端发送请求，只关心是否成功，不关心响应内容。
+func (bc *brokerClient) Oneway(ctx context.Context, method, path string, body io.Reader, header http.Header) error {
+	res, err := bc.Fetch(ctx, method, path, body, header)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	return res.Body.Close()
+}
+
+// JSON 以 JSON 格式发送 req（为 nil 时不发送请求体），并将响应 JSON 反序列化到 resp（为 nil 时丢弃响应）。
+func (bc *brokerClient) JSON(ctx context.Context, method, path string, req, resp any) error {
+	var body io.Reader
+	header := http.Header{"Accept": []string{"application/json"}}
+	if req != nil {
+		raw, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+		header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	res, err := bc.Fetch(ctx, method, path, body, header)
+	if err != nil {
+		return err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer res.Body.Close()
+
+	if resp == nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// OnewayJSON 以 JSON 格式发送 req，不关心响应内容。
+func (bc *brokerClient) OnewayJSON(ctx context.Context, method, path string, req any) error {
+	return bc.JSON(ctx, method, path, req, nil)
+}
+
+func (bc *brokerClient) fetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, fetchTimeout)
+}
+
+func (bc *brokerClient) fetch(ctx context.Context, method, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	endpoint := "http://vtun" + path
+
+	// 只有幂等请求才会重试，重试时需要重新发送请求体，所以先读取到内存中。
+	attempts := 1
+	var raw []byte
+	if idempotent(method) {
+		attempts += fetchRetries
+		if body != nil {
+			var err error
+			if raw, err = io.ReadAll(body); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			du := jitter(fetchBackoff<<(i-1), fetchBackoff<<i)
+			if after, ok := retryAfter(err); ok {
+				du = after
+			}
+			select {
+			case <-ctx.Done():
+				return nil, errors.Join(err, ctx.Err())
+			case <-time.After(du):
+			}
+		}
+
+		if raw != nil {
+			body = bytes.NewReader(raw)
+		}
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, method, endpoint, body); err != nil {
+			return nil, err
+		}
+		for k, vs := range header {
+			req.Header[k] = vs
+		}
+
+		var res *http.Response
+		if res, err = bc.fetcher.Do(req); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		if code := res.StatusCode; code >= 200 && code < 300 {
+			return res, nil
+		}
+
+		err = responseError(res)
+		_ = res.Body.Close()
+		if !retryable(res.StatusCode) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// responseError 将中心端的非 2xx 响应转为错误。
+// 如果中心端通过 Retry-After 指定了重试时间，则包装为 retryAfterError。
+func responseError(res *http.Response) error {
+	resp := make([]byte, errorBodySize)
+	n, _ := io.ReadFull(res.Body, resp)
+
+	var err error
+	pd := new(problem.Detail)
+	if exx := json.Unmarshal(resp[:n], pd); exx == nil && pd.Status != 0 {
+		err = pd
+	} else {
+		err = &netutil.HTTPError{Code: res.StatusCode, Body: resp[:n]}
+	}
+	if after := parseRetryAfter(res.Header.Get("Retry-After")); after > 0 {
+		err = &retryAfterError{err: err, after: after}
+	}
+
+	return err
+}
+
+// idempotent 判断请求方法是否幂等。
+//
+// https://developer.mozilla.org/zh-CN/docs/Glossary/Idempotent
+func idempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func retryable(code int) bool {
+	return code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// cancelBody 关闭响应 Body 时释放请求的 context。
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (cb *cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
@@ -0,0 +1,197 @@
+package telecom
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math"
+	"net"
+	"syscall"
+	"time"
+)
+
+// 地址的健康状态
+const (
+	AddrHealthy     = "healthy"     // 最近一次连接成功
+	AddrFailing     = "failing"     // 连续失败但还未被隔离
+	AddrQuarantined = "quarantined" // 连续失败已被隔离，隔离期内不会被优先选择
+)
+
+// 错误分类，不同类别的错误惩罚分不同。
+const (
+	errClassDNS       = "dns"
+	errClassTimeout   = "timeout"
+	errClassRefused   = "refused"
+	errClassTLS       = "tls"
+	errClassHandshake = "handshake"
+	errClassProxy     = "proxy"
+	errClassNetwork   = "network"
+)
+
+// AddressStat 中心端地址的健康统计信息。
+type AddressStat struct {
+	Addr         string        `json:"addr"`
+	TLS          bool          `json:"tls"`
+	Name         string        `json:"name"`
+	Proxy        string        `json:"proxy,omitempty"`
+	State        string        `json:"state"`
+	Score        float64       `json:"score"`
+	Succeeds     int64         `json:"succeeds"`
+	Failures     int64         `json:"failures"`
+	Continues    int           `json:"continues"`
+	Latency      time.Duration `json:"latency"`
+	ErrorClass   string        `json:"error_class"`
+	LastError    string        `json:"last_error"`
+	TriedAt      time.Time     `json:"tried_at"`
+	QuarantineAt time.Time     `json:"quarantine_at"`
+}
+
+// addrHealth 单个地址的健康状态。
+//
+// 评分由三部分组成：基础分 100，减去建连时延的扣分，再减去失败惩罚分。
+// 失败惩罚分按照错误类别累加，并且随时间指数衰减（半衰期 healthHalfLife），
+// 这样偶发失败的地址过一段时间后会自然恢复优先级。
+type addrHealth struct {
+	addr       *Address
+	succeeds   int64
+	failures   int64
+	continues  int           // 连续失败次数
+	latency    time.Duration // 建连耗时的滑动平均
+	penalty    float64       // 失败惩罚分（penaltyAt 时刻的值）
+	penaltyAt  time.Time
+	errClass   string
+	lastErr    string
+	triedAt    time.Time
+	quarantine time.Time // 隔离截止时间
+}
+
+const (
+	healthHalfLife      = 5 * time.Minute  // 惩罚分半衰期
+	quarantineBase      = 5 * time.Second  // 初次隔离时长
+	quarantineMax       = 10 * time.Minute // 最长隔离时长
+	quarantineThreshold = 2                // 连续失败多少次后开始隔离
+)
+
+func (ah *addrHealth) score(now time.Time) float64 {
+	ms := float64(ah.latency) / float64(time.Millisecond)
+	delay := math.Min(ms/20, 30) // 时延扣分，最多扣 30 分
+
+	return 100 - delay - ah.decayed(now)
+}
+
+func (ah *addrHealth) decayed(now time.Time) float64 {
+	if ah.penalty <= 0 {
+		return 0
+	}
+	elapsed := now.Sub(ah.penaltyAt)
+	return ah.penalty * math.Pow(0.5, float64(elapsed)/float64(healthHalfLife))
+}
+
+func (ah *addrHealth) quarantined(now time.Time) bool {
+	return now.Before(ah.quarantine)
+}
+
+func (ah *addrHealth) succeed(now time.Time, du time.Duration) {
+	ah.succeeds++
+	ah.continues = 0
+	ah.quarantine = time.Time{}
+	ah.penalty, ah.penaltyAt = ah.decayed(now)/2, now
+	if ah.latency == 0 {
+		ah.latency = du
+	} else {
+		ah.latency = (ah.latency*7 + du) / 8
+	}
+}
+
+func (ah *addrHealth) fail(now time.Time, class string, err error) {
+	weights := map[string]float64{
+		errClassDNS:       40,
+		errClassTimeout:   30,
+		errClassRefused:   20,
+		errClassTLS:       50,
+		errClassHandshake: 50,
+		errClassProxy:     30,
+		errClassNetwork:   20,
+	}
+
+	ah.failures++
+	ah.continues++
+	ah.errClass, ah.lastErr = class, err.Error()
+	ah.penalty, ah.penaltyAt = ah.decayed(now)+weights[class], now
+
+	if n := ah.continues - quarantineThreshold; n >= 0 {
+		du := quarantineBase << min(n, 16)
+		if du > quarantineMax {
+			du = quarantineMax
+		}
+		ah.quarantine = now.Add(du)
+	}
+}
+
+func (ah *addrHealth) stat(now time.Time) AddressStat {
+	state := AddrHealthy
+	if ah.quarantined(now) {
+		state = AddrQuarantined
+	} else if ah.continues > 0 {
+		state = AddrFailing
+	}
+
+	return AddressStat{
+		Addr:         ah.addr.Addr,
+		TLS:          ah.addr.TLS,
+		Name:         ah.addr.Name,
+		Proxy:        ah.addr.proxyRedacted(),
+		State:        state,
+		Score:        math.Round(ah.score(now)*100) / 100,
+		Succeeds:     ah.succeeds,
+		Failures:     ah.failures,
+		Continues:    ah.continues,
+		Latency:      ah.latency,
+		ErrorClass:   ah.errClass,
+		LastError:    ah.lastErr,
+		TriedAt:      ah.triedAt,
+		QuarantineAt: ah.quarantine,
+	}
+}
+
+// classifyError 对连接错误进行分类。
+func classifyError(err error) string {
+	var pe *proxyError
+	if errors.As(err, &pe) {
+		return errClassProxy
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return errClassDNS
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errClassTimeout
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return errClassTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return errClassRefused
+	}
+
+	if errors.Is(err, ErrCertPinMismatch) {
+		return errClassTLS
+	}
+
+	var (
+		recErr   tls.RecordHeaderError
+		alertErr tls.AlertError
+		certErr  *tls.CertificateVerificationError
+		authErr  x509.UnknownAuthorityError
+		hostErr  x509.HostnameError
+		invErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &recErr) || errors.As(err, &alertErr) || errors.As(err, &certErr) ||
+		errors.As(err, &authErr) || errors.As(err, &hostErr) || errors.As(err, &invErr) {
+		return errClassTLS
+	}
+
+	return errClassNetwork
+}
@@ -0,0 +1,163 @@
+package telecom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAddrHealthScore(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		latency time.Duration
+		penalty float64
+		elapsed time.Duration // 距离上次扣分的时间
+		want    float64
+	}{
+		{"新地址", 0, 0, 0, 100},
+		{"时延扣分", 200 * time.Millisecond, 0, 0, 90},
+		{"时延扣分最多 30 分", 10 * time.Second, 0, 0, 70},
+		{"刚失败", 0, 40, 0, 60},
+		{"惩罚分经过一个半衰期", 0, 40, healthHalfLife, 80},
+		{"惩罚分经过两个半衰期", 0, 40, 2 * healthHalfLife, 90},
+		{"时延与惩罚叠加", 100 * time.Millisecond, 20, healthHalfLife, 85},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ah := &addrHealth{latency: tt.latency, penalty: tt.penalty, penaltyAt: now.Add(-tt.elapsed)}
+			if got := ah.score(now); fmt.Sprintf("%.6f", got) != fmt.Sprintf("%.6f", tt.want) {
+				t.Errorf("score() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddrHealthFail(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		quarantine time.Duration // 最后一次失败后的隔离时长，0 代表未隔离
+		state      string
+	}{
+		{"失败一次", 1, 0, AddrFailing},
+		{"达到隔离阈值", quarantineThreshold, quarantineBase, AddrQuarantined},
+		{"隔离时长翻倍", quarantineThreshold + 2, quarantineBase << 2, AddrQuarantined},
+		{"隔离时长不超过上限", quarantineThreshold + 20, quarantineMax, AddrQuarantined},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			ah := &addrHealth{addr: &Address{Addr: "soc.example.com"}}
+			for range tt.failures {
+				ah.fail(now, errClassRefused, syscall.ECONNREFUSED)
+			}
+
+			var quarantine time.Duration
+			if !ah.quarantine.IsZero() {
+				quarantine = ah.quarantine.Sub(now)
+			}
+			if quarantine != tt.quarantine {
+				t.Errorf("quarantine = %s, want %s", quarantine, tt.quarantine)
+			}
+			if st := ah.stat(now); st.State != tt.state || st.Failures != int64(tt.failures) || st.ErrorClass != errClassRefused {
+				t.Errorf("stat = %+v, want state %s", st, tt.state)
+			}
+
+			ah.succeed(now, 100*time.Millisecond)
+			if st := ah.stat(now); st.State != AddrHealthy || st.Continues != 0 || st.Latency != 100*time.Millisecond {
+				t.Errorf("成功后 stat = %+v, want healthy", st)
+			}
+		})
+	}
+}
+
+func TestAddrHealthSucceed(t *testing.T) {
+	now := time.Now()
+	ah := &addrHealth{penalty: 40, penaltyAt: now}
+	ah.succeed(now, 80*time.Millisecond)
+	ah.succeed(now, 160*time.Millisecond)
+
+	if ah.latency != 90*time.Millisecond {
+		t.Errorf("latency = %s, want 90ms", ah.latency)
+	}
+	if ah.penalty != 10 {
+		t.Errorf("penalty = %f, want 10", ah.penalty)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"代理", &proxyError{proxy: "socks5://127.0.0.1:1080", err: syscall.ECONNREFUSED}, errClassProxy},
+		{"DNS", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "soc.example.com"}}, errClassDNS},
+		{"上下文超时", fmt.Errorf("dial: %w", context.DeadlineExceeded), errClassTimeout},
+		{"网络超时", &net.OpError{Op: "dial", Err: &timeoutError{}}, errClassTimeout},
+		{"连接被拒绝", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, errClassRefused},
+		{"证书指纹", fmt.Errorf("tls: %w", ErrCertPinMismatch), errClassTLS},
+		{"其它", errors.New("broken pipe"), errClassNetwork},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+func TestIterDialPick(t *testing.T) {
+	now := time.Now()
+	a := &Address{Addr: "a.example.com"}
+	b := &Address{Addr: "b.example.com"}
+	tests := []struct {
+		name    string
+		healths []*addrHealth
+		want    *Address
+		err     error
+	}{
+		{"没有地址", nil, nil, ErrNoAvailableAddress},
+		{"选择评分最高的地址", []*addrHealth{
+			{addr: a, penalty: 40, penaltyAt: now},
+			{addr: b},
+		}, b, nil},
+		{"评分相同选择最久未尝试的地址", []*addrHealth{
+			{addr: a, triedAt: now},
+			{addr: b, triedAt: now.Add(-time.Minute)},
+		}, b, nil},
+		{"跳过隔离中的地址", []*addrHealth{
+			{addr: a, quarantine: now.Add(time.Minute)},
+			{addr: b, penalty: 90, penaltyAt: now},
+		}, b, nil},
+		{"全部隔离时选择最早解除隔离的地址", []*addrHealth{
+			{addr: a, quarantine: now.Add(2 * time.Minute)},
+			{addr: b, quarantine: now.Add(time.Minute)},
+		}, b, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := &iterDial{healths: tt.healths}
+			got, err := dl.pick()
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("pick() = %v, %v, want %v, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
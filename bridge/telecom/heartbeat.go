@@ -0,0 +1,147 @@
+package telecom
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Heartbeat 应用层心跳配置。
+type Heartbeat struct {
+	// Interval 心跳间隔，默认 30s。
+	Interval time.Duration `json:"interval" yaml:"interval"`
+
+	// Timeout 超过该时长仍未收到任何心跳响应，即认为与中心端的连接已经失效（例如 TCP 半开），
+	// 此时会强制断开连接触发重连，默认为 3 倍的 Interval。
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+func (hb Heartbeat) interval() time.Duration {
+	if du := hb.Interval; du > 0 {
+		return du
+	}
+	return 30 * time.Second
+}
+
+func (hb Heartbeat) timeout() time.Duration {
+	if du := hb.Timeout; du > 0 {
+		return du
+	}
+	return 3 * hb.interval()
+}
+
+// HeartbeatStat 心跳统计信息。
+type HeartbeatStat struct {
+	RTT       time.Duration `json:"rtt"`        // 最近一次心跳的往返时延
+	SucceedAt time.Time     `json:"succeed_at"` // 最近一次心跳成功的时间
+	FailedAt  time.Time     `json:"failed_at"`  // 最近一次心跳失败的时间
+	Failures  int64         `json:"failures"`   // 心跳失败总次数
+	Continues int64         `json:"continues"`  // 当前连续失败次数
+	Stalls    int64         `json:"stalls"`     // 因心跳超时而强制重连的次数
+}
+
+// heartbeatState 心跳状态，会被心跳协程和外部查询并发访问。
+type heartbeatState struct {
+	mutex sync.Mutex
+	stat  HeartbeatStat
+	since time.Time // 超时计算的起点：连接建立时间或最近一次心跳成功时间
+	armed bool      // 是否需要检测超时，触发强制重连后直到重新连接成功前不再检测
+}
+
+func (hs *heartbeatState) Stat() HeartbeatStat {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	return hs.stat
+}
+
+// reset 连接（重连）成功后重置超时检测。
+func (hs *heartbeatState) reset(at time.Time) {
+	hs.mutex.Lock()
+	hs.since, hs.armed = at, true
+	hs.stat.Continues = 0
+	hs.mutex.Unlock()
+}
+
+// record 记录一次心跳结果，并返回是否已经超时需要强制重连。
+func (hs *heartbeatState) record(rtt time.Duration, err error, timeout time.Duration) bool {
+	now := time.Now()
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	if err == nil {
+		hs.since = now
+		hs.stat.RTT, hs.stat.SucceedAt, hs.stat.Continues = rtt, now, 0
+		return false
+	}
+
+	hs.stat.FailedAt = now
+	hs.stat.Failures++
+	hs.stat.Continues++
+	if !hs.armed || now.Sub(hs.since) < timeout {
+		return false
+	}
+	hs.armed = false
+	hs.stat.Stalls++
+
+	return true
+}
+
+// heartbeat 周期性的向中心端发送应用层心跳，测量往返时延。
+// 如果在超时窗口内一直没有收到响应，就强制关闭当前连接，
+// 由 Listen 的使用方感知到连接断开后调用 Reconnect 重连。
+func (bc *brokerClient) heartbeat(parent context.Context) {
+	cfg := bc.hide.Heartbeat
+	interval, timeout := cfg.interval(), cfg.timeout()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-parent.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rtt, err := bc.ping(parent, interval)
+		if !bc.beat.record(rtt, err, timeout) {
+			continue
+		}
+
+		stat := bc.beat.Stat()
+		bc.log.Warn("心跳超时，强制断开与中心端的连接",
+			slog.Any("timeout", timeout), slog.Any("succeed_at", stat.SucceedAt),
+			slog.Int64("continues", stat.Continues), slog.Any("error", err))
+		if mux := bc.mux.Load(); mux != nil {
+			_ = mux.Close()
+		}
+	}
+}
+
+// ping 发送一次心跳请求，中心端响应 2xx 才算成功：隧道另一端返回的 502 等错误说明中心端并不可用。
+func (bc *brokerClient) ping(parent context.Context, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	const endpoint = "http://vtun/api/v1/broker/ping"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	res, err := bc.pinger.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer res.Body.Close()
+
+	rtt := time.Since(start)
+	if res.StatusCode/100 != 2 {
+		return 0, responseError(res)
+	}
+
+	return rtt, nil
+}
@@ -0,0 +1,47 @@
+package telecom
+
+import "github.com/vela-ssoc/ssoc-common-mb/param/negotiate"
+
+// Hide broker 隐写配置。
+//
+// 在 negotiate.Hide 的基础上扩展了 broker 连接中心端时用到的可选参数，
+// 扩展字段缺省时均使用默认值，所以旧版本生成的隐写配置可以直接兼容。
+type Hide struct {
+	negotiate.Hide
+
+	// Servers 中心端地址，会覆盖 negotiate.Hide 中的同名字段，在其基础上增加了代理等配置。
+	Servers Addresses `json:"servers" yaml:"servers"`
+
+	// Discovery 通过 DNS 动态发现中心端地址。
+	Discovery DiscoveryConfig `json:"discovery" yaml:"discovery"`
+
+	// Heartbeat 与中心端之间的应用层心跳配置。
+	Heartbeat Heartbeat `json:"heartbeat" yaml:"heartbeat"`
+
+	// Backoff 连接中心端失败后的重连退避策略。
+	Backoff BackoffConfig `json:"backoff" yaml:"backoff"`
+
+	// TLS 连接中心端时的证书校验（私有 CA、公钥指纹）与客户端证书配置。
+	TLS TLSConfig `json:"tls" yaml:"tls"`
+
+	// Spool 隧道断开期间单向通知的离线队列配置。
+	Spool SpoolConfig `json:"spool" yaml:"spool"`
+}
+
+// Redacted 返回可以对外展示的配置：客户端证书私钥置空，出口代理地址中的认证密码隐藏。
+func (h Hide) Redacted() Hide {
+	h.TLS.Key = ""
+	servers := make(Addresses, 0, len(h.Servers))
+	for _, ad := range h.Servers {
+		if ad == nil {
+			continue
+		}
+		addr := *ad
+		addr.Proxy = ad.proxyRedacted()
+		servers = append(servers, &addr)
+	}
+	h.Servers = servers
+	h.Discovery.Proxy = Address{Proxy: h.Discovery.Proxy}.proxyRedacted()
+
+	return h
+}
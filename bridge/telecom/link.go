@@ -3,6 +3,7 @@ package telecom
 import (
 	"context"
 	"errors"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
@@ -12,43 +13,101 @@ import (
 	"github.com/vela-ssoc/vela-common-mba/netutil"
 )
 
-var ErrEmptyAddress = errors.New("服务端地址不能为空")
+var (
+	ErrEmptyAddress       = errors.New("服务端地址不能为空")
+	ErrNoAvailableAddress = errors.New("没有可用的服务端地址")
+)
 
 type Linker interface {
-	Hide() negotiate.Hide
+	Hide() Hide
 	Ident() negotiate.Ident
 	Issue() negotiate.Issue
 	Name() string
 	JoinAt() time.Time
 	Listen() net.Listener
 	Reconnect(context.Context) error
+
+	// OnIssue 注册配置变更回调：重连成功后中心端下发的 Issue 与之前不同时，
+	// 会在 Reconnect 返回前依次同步调用，回调中不要执行耗时操作。
+	OnIssue(fn func(old, cur negotiate.Issue))
 	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
-	// Fetch(context.Context, opcode.URLer, io.Reader, http.Header) (*http.Response, error)
-	// Oneway(context.Context, opcode.URLer, io.Reader, http.Header) error
-	// JSON(context.Context, opcode.URLer, any, any) error
-	// OnewayJSON(context.Context, opcode.URLer, any) error
+
+	// Heartbeat 与中心端的心跳统计信息。
+	Heartbeat() HeartbeatStat
+
+	// Addresses 中心端各个地址当前的健康状态。
+	Addresses() []AddressStat
+
+	// Fetch 向中心端发送请求，调用方负责关闭响应 Body。
+	Fetch(ctx context.Context, method, path string, body io.Reader, header http.Header) (*http.Response, error)
+
+	// Oneway 向中心端发送请求，不关心响应内容。
+	Oneway(ctx context.Context, method, path string, body io.Reader, header http.Header) error
+
+	// JSON 向中心端发送 JSON 请求并解析 JSON 响应。
+	JSON(ctx context.Context, method, path string, req, resp any) error
+
+	// OnewayJSON 向中心端发送 JSON 请求，不关心响应内容。
+	OnewayJSON(ctx context.Context, method, path string, req any) error
+
+	// Notify 向中心端发送单向通知，隧道断开期间会先写入磁盘队列，隧道恢复后按顺序重放。
+	Notify(ctx context.Context, method, path string, body []byte, header http.Header) error
+
+	// Deliver 与 Notify 相同，但是送达时返回中心端的真实响应（包括非 2xx），调用方负责关闭响应 Body；
+	// 只有隧道往返失败或者该目的地还有积压消息时才写入磁盘队列，此时返回的响应为 nil。
+	Deliver(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error)
+
+	// Spool 离线消息队列的统计信息。
+	Spool() SpoolStat
 }
 
-func Dial(parent context.Context, hide *negotiate.Hide, log *slog.Logger) (Linker, error) {
-	addrs := hide.Servers.Preformat()
+func Dial(parent context.Context, hide *Hide, log *slog.Logger) (Linker, error) {
+	dv := &discovery{
+		cfg:      hide.Discovery,
+		servers:  hide.Servers.Preformat(),
+		resolver: net.DefaultResolver,
+		log:      log,
+	}
+	addrs := dv.servers
+	if dv.cfg.enabled() {
+		addrs = dv.lookup(parent)
+	}
 	if len(addrs) == 0 {
 		return nil, ErrEmptyAddress
 	}
 
-	dialer := newIterDial(addrs)
+	tlsCfg, err := hide.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := newIterDial(addrs, tlsCfg)
 	bc := &brokerClient{
-		hide:   *hide,
-		log:    log,
-		dialer: dialer,
+		hide:    *hide,
+		log:     log,
+		dialer:  dialer,
+		backoff: NewBackoff(hide.Backoff),
 	}
 	trip := &http.Transport{DialContext: bc.dialContext}
 	bc.client = netutil.NewClient(trip)
+	bc.fetcher = &http.Client{Transport: trip}
+	// 心跳每次都新建 stream，这样测出来的才是真实的链路往返时延。
+	bc.pinger = &http.Client{
+		Transport: &http.Transport{DialContext: bc.dialContext, DisableKeepAlives: true},
+	}
+	if bc.spool, err = newSpool(hide.Spool, bc.sendSpool, log); err != nil {
+		return nil, err
+	}
 
-	if err := bc.dial(parent); err != nil {
+	if err = bc.dial(parent); err != nil {
 		return nil, err
 	}
 
-	// go bc.heartbeat(time.Minute)
+	go bc.heartbeat(parent)
+	if dv.cfg.enabled() {
+		go bc.discover(parent, dv)
+	}
+	go bc.spool.replay(parent)
 
 	return bc, nil
 }
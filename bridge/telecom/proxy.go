@@ -0,0 +1,155 @@
+package telecom
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// proxyError 代理服务器连接或隧道建立失败。
+type proxyError struct {
+	proxy string
+	err   error
+}
+
+func (e *proxyError) Error() string {
+	return "代理 " + e.proxy + " 连接失败：" + e.err.Error()
+}
+
+func (e *proxyError) Unwrap() error { return e.err }
+
+// proxyRedacted 隐藏代理认证密码，用于日志打印。
+func (ad Address) proxyRedacted() string {
+	if u, err := url.Parse(ad.Proxy); err == nil {
+		return u.Redacted()
+	}
+	return ad.Proxy
+}
+
+// dialProxy 通过代理与中心端地址建立 TCP 隧道，隧道建立后的 TLS 握手和协商与直连完全一致。
+func dialProxy(ctx context.Context, dialer *net.Dialer, rawURL, target string) (net.Conn, error) {
+	pu, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, &proxyError{proxy: rawURL, err: err}
+	}
+
+	var conn net.Conn
+	switch scheme := strings.ToLower(pu.Scheme); scheme {
+	case "http", "https":
+		conn, err = dialConnect(ctx, dialer, pu, target)
+	case "socks5", "socks5h":
+		conn, err = dialSOCKS5(ctx, dialer, pu, target)
+	default:
+		err = fmt.Errorf("不支持的代理协议：%s", scheme)
+	}
+	if err != nil {
+		return nil, &proxyError{proxy: pu.Redacted(), err: err}
+	}
+
+	return conn, nil
+}
+
+func dialSOCKS5(ctx context.Context, dialer *net.Dialer, pu *url.URL, target string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if user := pu.User; user != nil {
+		passwd, _ := user.Password()
+		auth = &proxy.Auth{User: user.Username(), Password: passwd}
+	}
+
+	host := proxyHost(pu, "1080")
+	socks, err := proxy.SOCKS5("tcp", host, auth, dialer)
+	if err != nil {
+		return nil, err
+	}
+	if cd, ok := socks.(proxy.ContextDialer); ok {
+		return cd.DialContext(ctx, "tcp", target)
+	}
+
+	return socks.Dial("tcp", target)
+}
+
+// dialConnect 通过 HTTP CONNECT 方法建立隧道。
+func dialConnect(ctx context.Context, dialer *net.Dialer, pu *url.URL, target string) (net.Conn, error) {
+	https := strings.EqualFold(pu.Scheme, "https")
+	port := "80"
+	if https {
+		port = "443"
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyHost(pu, port))
+	if err != nil {
+		return nil, err
+	}
+	if https {
+		tc := tls.Client(conn, &tls.Config{ServerName: pu.Hostname()})
+		if err = tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if user := pu.User; user != nil {
+		passwd, _ := user.Password()
+		basic := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + passwd))
+		req.Header.Set("Proxy-Authorization", "Basic "+basic)
+	}
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	rd := bufio.NewReader(conn)
+	res, err := http.ReadResponse(rd, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = res.Body.Close()
+	if code := res.StatusCode; code != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("代理服务器拒绝建立隧道：%s", res.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// 代理响应后可能已经预读了部分隧道数据。
+	if rd.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, rd: rd}, nil
+	}
+
+	return conn, nil
+}
+
+func proxyHost(pu *url.URL, port string) string {
+	if pu.Port() != "" {
+		return pu.Host
+	}
+	return net.JoinHostPort(pu.Hostname(), port)
+}
+
+type bufferedConn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(b []byte) (int, error) {
+	return bc.rd.Read(b)
+}
@@ -0,0 +1,430 @@
+package telecom
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-common-mb/problem"
+	"github.com/vela-ssoc/vela-common-mba/netutil"
+)
+
+var ErrSpoolFull = errors.New("离线消息队列已满")
+
+// SpoolConfig 离线消息队列配置。
+type SpoolConfig struct {
+	// Dir 离线消息存放目录，默认为工作目录下的 spool 目录。
+	Dir string `json:"dir" yaml:"dir"`
+
+	// MaxSize 离线消息最多占用的磁盘空间，默认 64MiB，超过后新消息会被丢弃。
+	MaxSize int64 `json:"max_size" yaml:"max_size"`
+}
+
+func (sc SpoolConfig) dir() string {
+	if dir := sc.Dir; dir != "" {
+		return dir
+	}
+	return "spool"
+}
+
+func (sc SpoolConfig) maxSize() int64 {
+	if size := sc.MaxSize; size > 0 {
+		return size
+	}
+	return 64 * 1024 * 1024
+}
+
+// SpoolStat 离线消息队列统计信息。
+type SpoolStat struct {
+	Depth    int64 `json:"depth"`    // 当前积压的消息数
+	Size     int64 `json:"size"`     // 当前积压消息占用的磁盘空间
+	Spooled  int64 `json:"spooled"`  // 累计落盘的消息数
+	Replayed int64 `json:"replayed"` // 累计重放成功的消息数
+	Dropped  int64 `json:"dropped"`  // 累计因队列已满而丢弃的消息数
+	Rejected int64 `json:"rejected"` // 累计重放时被中心端拒绝而丢弃的消息数
+}
+
+// spoolMessage 落盘的单向通知消息。
+type spoolMessage struct {
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// key 同一个目的地（请求方法 + 路径）的消息按照先后顺序投递，不同目的地之间互不影响。
+func (sm *spoolMessage) key() string {
+	sum := sha256.Sum256([]byte(sm.Method + " " + sm.Path))
+	return hex.EncodeToString(sum[:8])
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+// spool 单向通知的磁盘队列。
+//
+// 隧道断开期间发往中心端的单向通知会先写入磁盘，每个目的地一个子目录，
+// 文件名为全局递增序号。隧道恢复后按序号依次重放，某个目的地重放失败时
+// 该目的地后续的消息也会暂停投递，以保证同一目的地的消息顺序。
+type spool struct {
+	dir    string
+	max    int64
+	send   func(context.Context, *spoolMessage) error
+	log    *slog.Logger
+	wakeup chan struct{}
+	mutex  sync.Mutex
+	seq    uint64
+	queues map[string][]spoolFile
+	sends  map[string]*sync.Mutex // 每个目的地直接发送时持有的锁，保证检查积压、发送、落盘的顺序
+	stat   SpoolStat
+}
+
+func newSpool(cfg SpoolConfig, send func(context.Context, *spoolMessage) error, log *slog.Logger) (*spool, error) {
+	sp := &spool{
+		dir:    cfg.dir(),
+		max:    cfg.maxSize(),
+		send:   send,
+		log:    log,
+		wakeup: make(chan struct{}, 1),
+		queues: make(map[string][]spoolFile, 8),
+		sends:  make(map[string]*sync.Mutex, 8),
+	}
+	if err := sp.load(); err != nil {
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+// load 加载上次运行时未投递的消息。
+func (sp *spool) load() error {
+	if err := os.MkdirAll(sp.dir, 0o700); err != nil {
+		return err
+	}
+	dirs, err := os.ReadDir(sp.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		key := dir.Name()
+		entries, _ := os.ReadDir(filepath.Join(sp.dir, key))
+		files := make([]spoolFile, 0, len(entries))
+		for _, ent := range entries {
+			name := ent.Name()
+			seq, exx := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+			if exx != nil || !strings.HasSuffix(name, ".json") {
+				// 写入到一半的临时文件
+				_ = os.Remove(filepath.Join(sp.dir, key, name))
+				continue
+			}
+			info, exx := ent.Info()
+			if exx != nil {
+				continue
+			}
+			files = append(files, spoolFile{name: name, size: info.Size()})
+			sp.seq = max(sp.seq, seq)
+			sp.stat.Size += info.Size()
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		slices.SortFunc(files, func(a, b spoolFile) int { return strings.Compare(a.name, b.name) })
+		sp.queues[key] = files
+		sp.stat.Depth += int64(len(files))
+	}
+
+	return nil
+}
+
+// notify 投递单向通知：该目的地没有积压消息时直接发送，发送失败或已有积压时写入磁盘等待重放。
+//
+// 同一目的地的通知串行投递：如果并发发送，先发送的失败落盘后，会排在后发送且已经成功的消息之后，
+// 所以检查积压、发送和落盘要在该目的地的锁内完成。
+func (sp *spool) notify(ctx context.Context, msg *spoolMessage) error {
+	key := msg.key()
+	unlock := sp.lock(key)
+	defer unlock()
+
+	if !sp.pending(key) {
+		err := sp.send(ctx, msg)
+		if err == nil || !spoolable(err) {
+			return err
+		}
+	}
+
+	return sp.enqueue(key, msg)
+}
+
+// deliver 投递消息并返回中心端的响应：该目的地没有积压且隧道往返成功时，无论状态码是多少都原样返回响应，
+// 隧道往返失败或已有积压时写入磁盘，返回 nil 响应。
+func (sp *spool) deliver(ctx context.Context, msg *spoolMessage, roundTrip func(context.Context, *spoolMessage) (*http.Response, error)) (*http.Response, error) {
+	key := msg.key()
+	unlock := sp.lock(key)
+	defer unlock()
+
+	if !sp.pending(key) {
+		if res, err := roundTrip(ctx, msg); err == nil {
+			return res, nil
+		}
+	}
+
+	return nil, sp.enqueue(key, msg)
+}
+
+// lock 获取该目的地直接发送时持有的锁，返回解锁函数。
+func (sp *spool) lock(key string) func() {
+	sp.mutex.Lock()
+	lock := sp.sends[key]
+	if lock == nil {
+		lock = new(sync.Mutex)
+		sp.sends[key] = lock
+	}
+	sp.mutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// pending 该目的地是否还有积压的消息。
+func (sp *spool) pending(key string) bool {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return len(sp.queues[key]) != 0
+}
+
+func (sp *spool) enqueue(key string, msg *spoolMessage) error {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	size := int64(len(raw))
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if sp.stat.Size+size > sp.max {
+		sp.stat.Dropped++
+		return ErrSpoolFull
+	}
+
+	dir := filepath.Join(sp.dir, key)
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	sp.seq++
+	name := strconv.FormatUint(sp.seq, 10)
+	name = strings.Repeat("0", 20-len(name)) + name + ".json" // 补零保证文件名排序与序号一致
+	temp := filepath.Join(dir, name+".tmp")
+	if err = os.WriteFile(temp, raw, 0o600); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	if err = os.Rename(temp, filepath.Join(dir, name)); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+
+	sp.queues[key] = append(sp.queues[key], spoolFile{name: name, size: size})
+	sp.stat.Depth++
+	sp.stat.Size += size
+	sp.stat.Spooled++
+
+	return nil
+}
+
+// Notify 发送单向通知，发送失败时写入离线队列。写入队列成功即返回 nil。
+func (bc *brokerClient) Notify(ctx context.Context, method, path string, body []byte, header http.Header) error {
+	msg := &spoolMessage{Method: method, Path: path, Header: header, Body: body, CreatedAt: time.Now()}
+	return bc.spool.notify(ctx, msg)
+}
+
+// Deliver 投递消息，送达时返回中心端的真实响应，写入离线队列时返回 nil 响应。
+func (bc *brokerClient) Deliver(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	msg := &spoolMessage{Method: method, Path: path, Header: header, Body: body, CreatedAt: time.Now()}
+	return bc.spool.deliver(ctx, msg, bc.roundTrip)
+}
+
+// roundTrip 通过隧道发送一次请求，不重试，也不把非 2xx 响应转为错误。
+func (bc *brokerClient) roundTrip(ctx context.Context, msg *spoolMessage) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, msg.Method, "http://vtun"+msg.Path, bytes.NewReader(msg.Body))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range msg.Header {
+		req.Header[k] = vs
+	}
+
+	return bc.fetcher.Do(req)
+}
+
+// NotifyTransport 通过 Deliver 投递请求的 RoundTripper，供告警等需要在隧道断开期间保证送达的
+// HTTP 客户端使用。请求的 host 会被忽略；送达时返回中心端的真实响应，隧道往返失败写入离线队列时
+// 返回 202 空响应。
+func NotifyTransport(link Linker) http.RoundTripper {
+	return &notifyTransport{link: link}
+}
+
+type notifyTransport struct {
+	link Linker
+}
+
+func (nt *notifyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		raw, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = raw
+	}
+	res, err := nt.link.Deliver(req.Context(), req.Method, req.URL.RequestURI(), body, req.Header.Clone())
+	if err != nil || res != nil {
+		return res, err
+	}
+
+	res = &http.Response{
+		Status:     "202 Accepted",
+		StatusCode: http.StatusAccepted,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+
+	return res, nil
+}
+
+func (bc *brokerClient) sendSpool(ctx context.Context, msg *spoolMessage) error {
+	return bc.Oneway(ctx, msg.Method, msg.Path, bytes.NewReader(msg.Body), msg.Header)
+}
+
+// wake 隧道重连成功后唤醒重放。
+func (sp *spool) wake() {
+	select {
+	case sp.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (sp *spool) Stat() SpoolStat {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return sp.stat
+}
+
+// replay 后台重放积压的消息，除了隧道恢复后被唤醒，还会定期重试之前重放失败的目的地。
+func (sp *spool) replay(parent context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-parent.Done():
+			return
+		case <-sp.wakeup:
+		case <-ticker.C:
+		}
+
+		sp.mutex.Lock()
+		keys := make([]string, 0, len(sp.queues))
+		for key, files := range sp.queues {
+			if len(files) != 0 {
+				keys = append(keys, key)
+			}
+		}
+		sp.mutex.Unlock()
+
+		for _, key := range keys {
+			if err := sp.drain(parent, key); err != nil {
+				sp.log.Warn("离线消息重放失败", slog.String("queue", key), slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// drain 按顺序重放某个目的地的全部积压消息，遇到可重试的错误就停止，等待下一轮重放。
+func (sp *spool) drain(parent context.Context, key string) error {
+	for {
+		sp.mutex.Lock()
+		files := sp.queues[key]
+		if len(files) == 0 {
+			delete(sp.queues, key)
+			sp.mutex.Unlock()
+			return nil
+		}
+		head := files[0]
+		sp.mutex.Unlock()
+
+		fp := filepath.Join(sp.dir, key, head.name)
+		msg := new(spoolMessage)
+		raw, err := os.ReadFile(fp)
+		if err == nil {
+			err = json.Unmarshal(raw, msg)
+		}
+		rejected := err != nil // 文件损坏，无法重放
+		if !rejected {
+			if err = sp.send(parent, msg); err != nil {
+				if spoolable(err) {
+					return err
+				}
+				rejected = true
+				sp.log.Warn("离线消息被中心端拒绝，已丢弃", slog.String("method", msg.Method),
+					slog.String("path", msg.Path), slog.Any("error", err))
+			}
+		}
+
+		_ = os.Remove(fp)
+		sp.mutex.Lock()
+		sp.queues[key] = sp.queues[key][1:]
+		sp.stat.Depth--
+		sp.stat.Size -= head.size
+		if rejected {
+			sp.stat.Rejected++
+		} else {
+			sp.stat.Replayed++
+		}
+		sp.mutex.Unlock()
+	}
+}
+
+// spoolable 判断发送失败的消息是否需要落盘稍后重放：
+// 隧道错误、中心端 5xx 408 429 需要重放，其余 4xx 说明消息本身有问题，重放也没有意义。
+func spoolable(err error) bool {
+	code := 0
+	var he *netutil.HTTPError
+	var pd *problem.Detail
+	if errors.As(err, &he) {
+		code = he.Code
+	} else if errors.As(err, &pd) {
+		code = pd.Status
+	}
+	if code < 400 || code >= 500 {
+		return true
+	}
+
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
@@ -0,0 +1,69 @@
+package telecom
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSpoolDeliver(t *testing.T) {
+	upstream := func(code int) func(context.Context, *spoolMessage) (*http.Response, error) {
+		return func(context.Context, *spoolMessage) (*http.Response, error) {
+			return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader("upstream"))}, nil
+		}
+	}
+	broken := func(context.Context, *spoolMessage) (*http.Response, error) {
+		return nil, errors.New("隧道已断开")
+	}
+
+	tests := []struct {
+		name      string
+		pending   bool // 该目的地是否已有积压
+		roundTrip func(context.Context, *spoolMessage) (*http.Response, error)
+		code      int // 期望返回的状态码，0 代表写入离线队列
+	}{
+		{"送达", false, upstream(http.StatusOK), http.StatusOK},
+		{"送达时原样返回错误响应", false, upstream(http.StatusBadRequest), http.StatusBadRequest},
+		{"中心端 5xx 不落盘", false, upstream(http.StatusBadGateway), http.StatusBadGateway},
+		{"隧道往返失败", false, broken, 0},
+		{"已有积压时落盘保证顺序", true, upstream(http.StatusOK), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp, err := newSpool(SpoolConfig{Dir: t.TempDir()}, nil, slog.Default())
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := &spoolMessage{Method: http.MethodPost, Path: "/proxy/siem/event", Body: []byte("{}")}
+			if tt.pending {
+				if err = sp.enqueue(msg.key(), msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := sp.Stat().Spooled
+
+			res, err := sp.deliver(context.Background(), msg, tt.roundTrip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spooled := sp.Stat().Spooled - before
+			if tt.code == 0 {
+				if res != nil || spooled != 1 {
+					t.Errorf("res = %v spooled = %d, want 写入离线队列", res, spooled)
+				}
+				return
+			}
+			if res == nil || res.StatusCode != tt.code || spooled != 0 {
+				t.Fatalf("res = %v spooled = %d, want %d", res, spooled, tt.code)
+			}
+			if body, _ := io.ReadAll(res.Body); string(body) != "upstream" {
+				t.Errorf("body = %s, want upstream", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package telecom
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCA       = errors.New("中心端 CA 证书格式错误")
+	ErrCertPinMismatch = errors.New("中心端证书公钥指纹不匹配")
+)
+
+// TLSConfig 连接中心端时的 TLS 配置，随隐写配置一起下发，不配置时使用系统根证书校验中心端证书。
+type TLSConfig struct {
+	// CA 中心端证书的签发 CA（PEM 格式，可以包含多个证书）。
+	// 配置后只信任这些 CA 签发的证书，不再使用系统根证书。
+	CA string `json:"ca" yaml:"ca"`
+
+	// Pins 中心端证书公钥（SubjectPublicKeyInfo）的 SHA-256 指纹，base64 编码，可带 sha256/ 前缀。
+	// 同时配置了 CA 时，校验通过的证书链中任意一个证书的公钥指纹与其中之一相同即视为匹配。
+	// 如果只配置了 Pins 没有配置 CA，则以指纹作为信任锚，只比对中心端出示的叶子证书，
+	// 不再校验证书链，但仍然校验域名和有效期。
+	Pins []string `json:"pins" yaml:"pins"`
+
+	// Cert 客户端证书（PEM 格式），与 Key 同时配置后 broker 在 TLS 握手时出示客户端证书，
+	// 中心端可以据此在传输层就完成双向认证。
+	Cert string `json:"cert" yaml:"cert"`
+
+	// Key 客户端证书私钥（PEM 格式）。
+	Key string `json:"key" yaml:"key"`
+}
+
+// config 根据配置生成 tls.Config 模板，使用时需要 Clone 后再设置 ServerName。
+func (tc TLSConfig) config() (*tls.Config, error) {
+	cfg := new(tls.Config)
+	if ca := tc.CA; ca != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, ErrInvalidCA
+		}
+		cfg.RootCAs = pool
+	}
+
+	if tc.Cert != "" || tc.Key != "" {
+		pair, err := tls.X509KeyPair([]byte(tc.Cert), []byte(tc.Key))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	pins := make(map[string]struct{}, len(tc.Pins))
+	for _, pin := range tc.Pins {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		if pin != "" {
+			pins[pin] = struct{}{}
+		}
+	}
+	if len(pins) == 0 {
+		return cfg, nil
+	}
+
+	// 只有指纹没有 CA 时由 VerifyConnection 完成全部校验。
+	pinOnly := cfg.RootCAs == nil
+	cfg.InsecureSkipVerify = pinOnly
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if pinOnly {
+			// 没有校验证书链，证书链中除叶子证书外的证书都可能是伪造方附带的，只能比对叶子证书。
+			if len(cs.PeerCertificates) == 0 {
+				return ErrCertPinMismatch
+			}
+			leaf := cs.PeerCertificates[0]
+			if err := leaf.VerifyHostname(cs.ServerName); err != nil {
+				return err
+			}
+			if now := time.Now(); now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+				return x509.CertificateInvalidError{Cert: leaf, Reason: x509.Expired}
+			}
+			if matchPin(pins, leaf) {
+				return nil
+			}
+			return ErrCertPinMismatch
+		}
+
+		// 配置了 CA 时只比对已经校验通过的证书链。
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				if matchPin(pins, cert) {
+					return nil
+				}
+			}
+		}
+
+		return ErrCertPinMismatch
+	}
+
+	return cfg, nil
+}
+
+// matchPin 证书公钥指纹是否在 pins 中。
+func matchPin(pins map[string]struct{}, cert *x509.Certificate) bool {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	_, ok := pins[base64.StdEncoding.EncodeToString(sum[:])]
+	return ok
+}
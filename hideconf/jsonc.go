@@ -6,14 +6,14 @@ import (
 	"io"
 	"os"
 
+	"github.com/vela-ssoc/ssoc-broker/bridge/telecom"
 	"github.com/vela-ssoc/ssoc-common-mb/jsonc"
-	"github.com/vela-ssoc/ssoc-common-mb/param/negotiate"
 )
 
 const DevMode = true
 
-func Read(file string) (*negotiate.Hide, error) {
-	hide := new(negotiate.Hide)
+func Read(file string) (*telecom.Hide, error) {
+	hide := new(telecom.Hide)
 	if file != "" {
 		if err := unmarshalJSONC(file, hide); err != nil {
 			return nil, err
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/vela-ssoc/ssoc-broker/bridge/telecom"
 	"github.com/vela-ssoc/ssoc-common-mb/param/negotiate"
@@ -13,11 +14,12 @@ import (
 )
 
 type daemonServer struct {
-	hide    *negotiate.Hide // 隐写配置
-	issue   negotiate.Issue // 服务监听配置
-	handler http.Handler    // handler
-	server  *http.Server    // HTTP 服务
-	errCh   chan<- error    // 错误输出
+	hide    *telecom.Hide                   // 隐写配置
+	issue   negotiate.Issue                 // 服务监听配置
+	handler http.Handler                    // handler
+	server  *http.Server                    // HTTP 服务
+	errCh   chan<- error                    // 错误输出
+	cert    atomic.Pointer[tls.Certificate] // 监听证书，支持热替换
 }
 
 func (ds *daemonServer) Run() {
@@ -34,18 +36,15 @@ func (ds *daemonServer) Run() {
 	tcpSrv := &http.Server{Handler: ds.handler}
 
 	var tlsFunc func(net.Conn)
-	cert, pkey := srvCfg.Cert, srvCfg.Pkey
-	if cert != "" && pkey == "" {
-		pair, err := tls.X509KeyPair([]byte(cert), []byte(pkey))
-		if err != nil {
-			ds.errCh <- err
-			return
-		}
-
+	if err = ds.reload(ds.issue); err != nil {
+		ds.errCh <- err
+		return
+	}
+	if ds.cert.Load() != nil {
 		tcpSrv.Handler = &onlyDeploy{h: tcpSrv.Handler}
 		tlsSrv := &http.Server{
 			Handler:   ds.handler,
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{pair}},
+			TLSConfig: &tls.Config{GetCertificate: ds.getCertificate},
 		}
 
 		tlsFunc = func(conn net.Conn) {
@@ -61,6 +60,28 @@ func (ds *daemonServer) Run() {
 	ds.errCh <- prereadtls.Serve(lis, tcpFunc, tlsFunc)
 }
 
+// reload 加载中心端下发的证书，重连后证书变化时调用可以热替换，已建立的连接不受影响。
+// 启动时没有配置证书的情况下不会开启 TLS，之后再下发证书需要重启才能生效。
+func (ds *daemonServer) reload(issue negotiate.Issue) error {
+	srvCfg := issue.Server
+	cert, pkey := srvCfg.Cert, srvCfg.Pkey
+	if cert == "" || pkey == "" {
+		return nil
+	}
+
+	pair, err := tls.X509KeyPair([]byte(cert), []byte(pkey))
+	if err != nil {
+		return err
+	}
+	ds.cert.Store(&pair)
+
+	return nil
+}
+
+func (ds *daemonServer) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return ds.cert.Load(), nil
+}
+
 func (ds *daemonServer) Close() error {
 	if srv := ds.server; srv != nil {
 		return srv.Close()
@@ -0,0 +1,86 @@
+package launch
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/vela-ssoc/ssoc-common-mb/param/negotiate"
+)
+
+// testKeyPair 生成 PEM 格式的自签名证书与私钥。
+func testKeyPair(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	priv := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: pkey})
+
+	return string(cert), string(priv)
+}
+
+func TestDaemonServerReload(t *testing.T) {
+	cert1, pkey1 := testKeyPair(t, "broker1.example.com")
+	cert2, pkey2 := testKeyPair(t, "broker2.example.com")
+
+	tests := []struct {
+		name    string
+		cert    string
+		pkey    string
+		want    string // 加载后证书的 CN，空代表没有证书
+		wantErr bool
+	}{
+		{name: "没有证书", want: ""},
+		{name: "只有证书没有私钥", cert: cert1, want: ""},
+		{name: "加载证书", cert: cert1, pkey: pkey1, want: "broker1.example.com"},
+		{name: "证书变化后热替换", cert: cert2, pkey: pkey2, want: "broker2.example.com"},
+		{name: "证书与私钥不匹配时保留原证书", cert: cert1, pkey: pkey2, want: "broker2.example.com", wantErr: true},
+		{name: "之后不再下发证书时保留原证书", want: "broker2.example.com"},
+	}
+
+	ds := new(daemonServer)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var issue negotiate.Issue
+			issue.Server.Cert, issue.Server.Pkey = tt.cert, tt.pkey
+			if err := ds.reload(issue); (err != nil) != tt.wantErr {
+				t.Fatalf("reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var got string
+			if pair, _ := ds.getCertificate(nil); pair != nil {
+				leaf, err := x509.ParseCertificate(pair.Certificate[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = leaf.Subject.CommonName
+			}
+			if got != tt.want {
+				t.Errorf("certificate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
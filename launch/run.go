@@ -44,7 +44,7 @@ import (
 )
 
 // Run 运行服务
-func Run(parent context.Context, hide *negotiate.Hide) error {
+func Run(parent context.Context, hide *telecom.Hide) error {
 	tempLogCfg := profile.Logger{Console: true}
 	logWriter := tempLogCfg.LogWriter()
 	logOption := &slog.HandlerOptions{AddSource: true, Level: logWriter.Level()}
@@ -89,10 +89,14 @@ func Run(parent context.Context, hide *negotiate.Hide) error {
 	//goland:noinspection GoUnhandledErrorResult
 	defer sdb.Close() // 程序结束时断开数据库连接。
 
-	sdb.SetMaxOpenConns(dbCfg.MaxOpenConn)
-	sdb.SetMaxIdleConns(dbCfg.MaxIdleConn)
-	sdb.SetConnMaxLifetime(dbCfg.MaxLifeTime.Duration())
-	sdb.SetConnMaxIdleTime(dbCfg.MaxIdleTime.Duration())
+	setPool := func(issue negotiate.Issue) {
+		dbCfg := issue.Database
+		sdb.SetMaxOpenConns(dbCfg.MaxOpenConn)
+		sdb.SetMaxIdleConns(dbCfg.MaxIdleConn)
+		sdb.SetConnMaxLifetime(dbCfg.MaxLifeTime.Duration())
+		sdb.SetConnMaxIdleTime(dbCfg.MaxIdleTime.Duration())
+	}
+	setPool(issue)
 	log.Warn("当前数据库类型", slog.String("dialect", db.Dialector.Name()))
 
 	qry := query.Use(db)
@@ -102,10 +106,9 @@ func Run(parent context.Context, hide *negotiate.Hide) error {
 	match := ntfmatch.NewMatch(qry)
 	store := storage.NewStore(qry)
 
+	// 告警只关心是否送达，隧道断开期间写入离线队列，恢复后重放。
 	tunCli := &http.Client{
-		Transport: &http.Transport{
-			DialContext: link.DialContext,
-		},
+		Transport: telecom.NotifyTransport(link),
 	}
 	dongCli := dong.NewTunnel(tunCli, log)
 	devopsCfg := devops.NewConfig(store)
@@ -142,8 +145,15 @@ func Run(parent context.Context, hide *negotiate.Hide) error {
 	_ = vsync
 
 	nodeEventService := agtsvc.Phase(cmdbCli, alert, log)
-	hub := mlink.LinkHub(qry, link, agt, nodeEventService, log)
+	phaseBus := mlink.NewPhaseBus(name, log)
+	phaseBus.Hook(nodeEventService.Push, mlink.PhaseCreated, mlink.PhaseConnected)
+	phaseBus.Subscribe(nodeEventService, 4096)
+	if err = phaseBus.Load(parent, qry); err != nil {
+		log.Warn("加载节点生命周期事件订阅配置出错", slog.Any("error", err))
+	}
+	hub := mlink.LinkHub(parent, qry, link, agt, phaseBus, log)
 	_ = hub.ResetDB()
+	gw := gateway.New(hub)
 
 	minionService := mgtsvc.Minion(qry)
 	agentService := mgtsvc.Agent(qry, hub, minionService, store, log)
@@ -165,9 +175,19 @@ func Run(parent context.Context, hide *negotiate.Hide) error {
 
 		systemSvc := mservice.NewSystem(link, qry, gfs, log)
 		taskSvc := mservice.NewTask(qry, hub, log)
+		drainSvc := mservice.NewDrain(hub, systemSvc, log)
+		connectSvc := mservice.NewConnect(hub, gw)
+		banSvc := mservice.NewBan(hub, log)
+		admissionSvc := mservice.NewAdmission(hub)
+		captureSvc := mservice.NewCapture(hub, log)
 		routers := []shipx.RouteBinder{
 			mrestapi.NewSystem(systemSvc),
 			mrestapi.NewTask(taskSvc),
+			mrestapi.NewDrain(drainSvc),
+			mrestapi.NewConnect(connectSvc),
+			mrestapi.NewBan(banSvc),
+			mrestapi.NewAdmission(admissionSvc),
+			mrestapi.NewCapture(captureSvc),
 		}
 		if err = shipx.BindRouters(mv1, routers); err != nil {
 			return err
@@ -194,7 +214,7 @@ func Run(parent context.Context, hide *negotiate.Hide) error {
 		heartREST := agtapi.Heart()
 		heartREST.Route(av1)
 
-		proxyAPI := agtapi.Proxy(link.DialContext)
+		proxyAPI := agtapi.Proxy(link)
 		proxyAPI.Route(av1)
 
 		securityREST := agtapi.Security(qry)
@@ -225,7 +245,6 @@ func Run(parent context.Context, hide *negotiate.Hide) error {
 
 	oldHandler := linkhub.New(db, qry, link, log, gfs)
 	temp := temporary.REST(oldHandler, valid, log)
-	gw := gateway.New(hub)
 	deployService := agtsvc.Deploy(qry, store, gfs, ident.ID)
 	deployAPI := agtapi.Deploy(deployService)
 
@@ -245,6 +264,19 @@ func Run(parent context.Context, hide *negotiate.Hide) error {
 	ds := &daemonServer{issue: issue, hide: hide, handler: mux, errCh: errCh}
 	go ds.Run()
 
+	// 重连后中心端下发的配置发生变化时热更新，不再需要重启 broker。
+	link.OnIssue(func(old, cur negotiate.Issue) {
+		_ = logWriter.Level().UnmarshalText([]byte(cur.Logger.Level))
+		setPool(cur)
+		if err := ds.reload(cur); err != nil {
+			log.Warn("监听证书热更新失败", slog.Any("error", err))
+		}
+		if old.Server.Addr != cur.Server.Addr {
+			log.Warn("监听地址发生变化，需要重启后才能生效", slog.String("old", old.Server.Addr), slog.String("new", cur.Server.Addr))
+		}
+		log.Info("中心端下发的配置已热更新")
+	})
+
 	// 连接 manager 的客户端，保持在线与接受指令
 	dc := &daemonClient{link: link, handler: mgt, errCh: errCh, log: log, parent: parent}
 	go dc.Run()
@@ -257,6 +289,7 @@ func Run(parent context.Context, hide *negotiate.Hide) error {
 	_ = ds.Close()
 	_ = dc.Close()
 	_ = hub.ResetDB()
+	_ = phaseBus.Close()
 
 	return err
 }